@@ -0,0 +1,157 @@
+package bucket
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Type OSSBucket represents an Alibaba Cloud Object Storage Service bucket.
+type OSSBucket struct {
+	// The name of the bucket.
+	name string
+
+	// The endpoint hosting the bucket, e.g. oss-cn-hangzhou.aliyuncs.com.
+	endpoint string
+
+	// The region of the bucket, e.g. cn-hangzhou.
+	region string
+}
+
+// Type OSSBucketPage is a helper type for storing XML data.
+type OSSBucketPage struct {
+	XMLName      xml.Name `xml:"ListBucketResult"`
+	Text         string   `xml:",chardata"`
+	Name         string   `xml:"Name"`
+	Prefix       string   `xml:"Prefix"`
+	Marker       string   `xml:"Marker"`
+	MaxKeys      string   `xml:"MaxKeys"`
+	Delimiter    string   `xml:"Delimiter"`
+	EncodingType string   `xml:"EncodingType"`
+	IsTruncated  bool     `xml:"IsTruncated"`
+	NextMarker   string   `xml:"NextMarker"`
+	Contents     []struct {
+		Text         string `xml:",chardata"`
+		Key          string `xml:"Key"`
+		LastModified string `xml:"LastModified"`
+		ETag         string `xml:"ETag"`
+		Type         string `xml:"Type"`
+		Size         string `xml:"Size"`
+		StorageClass string `xml:"StorageClass"`
+	} `xml:"Contents"`
+}
+
+// Type OSSError is an error document returned by OSS.
+type OSSError struct {
+	XMLName    xml.Name `xml:"Error"`
+	Code       string   `xml:"Code"`
+	Message    string   `xml:"Message"`
+	RequestID  string   `xml:"RequestId"`
+	HostID     string   `xml:"HostId"`
+	BucketName string   `xml:"BucketName"`
+	Endpoint   string   `xml:"Endpoint"`
+}
+
+func (e OSSError) Error() string {
+	switch e.Code {
+	case "NoSuchBucket":
+		return fmt.Sprintf("OSS bucket %s does not exist", e.BucketName)
+	case "SecondLevelDomainForbidden":
+		return "OSS rejected the request because the bucket must be addressed by subdomain"
+	case "AccessDenied":
+		if e.Endpoint != "" {
+			return fmt.Sprintf("OSS bucket must be accessed through endpoint %s", e.Endpoint)
+		}
+		return fmt.Sprintf("OSS bucket listing is not public: %s", e.Message)
+	}
+	return fmt.Sprintf("OSS error %s: %s", e.Code, e.Message)
+}
+
+func NewOSSBucket(name string, endpoint string) OSSBucket {
+	endpoint = strings.ToLower(endpoint)
+	region := strings.TrimSuffix(endpoint, ".aliyuncs.com")
+	region = strings.TrimPrefix(region, "oss-")
+	region = strings.TrimSuffix(region, "-internal")
+	return OSSBucket{
+		name:     name,
+		endpoint: endpoint,
+		region:   region,
+	}
+}
+
+// Returns the name of the bucket.
+func (bucket OSSBucket) Name() string {
+	return fmt.Sprintf("%s-%s", bucket.endpoint, bucket.name)
+}
+
+// Returns the region the bucket is hosted in.
+func (bucket OSSBucket) Region() string {
+	return bucket.region
+}
+
+// Returns the URL of the bucket. OSS forbids path-style access, so this
+// is always the virtual-hosted form.
+func (bucket OSSBucket) URL() string {
+	return fmt.Sprintf("https://%s.%s/", bucket.name, bucket.endpoint)
+}
+
+// Returns the URL pointing to the position in the bucket indicated by the pagination key.
+func (bucket OSSBucket) PageURL(paginationKey string) string {
+	if paginationKey == "" {
+		return fmt.Sprintf("%s?encoding-type=url", bucket.URL())
+	}
+	return fmt.Sprintf("%s?encoding-type=url&marker=%s", bucket.URL(), url.QueryEscape(paginationKey))
+}
+
+// Returns the URL used to fetch the resource with the specified key.
+func (bucket OSSBucket) ResourceURL(key string) string {
+	return fmt.Sprintf("%s%s", bucket.URL(), escapePath(key))
+}
+
+// Resumes a stalled listing with the last key listed as the marker,
+// rather than the NextMarker returned by the provider.
+func (bucket OSSBucket) Recover(lastKey string) (string, bool) {
+	if lastKey == "" {
+		return "", false
+	}
+	return lastKey, true
+}
+
+// Parses a response to a page request and returns a slice of the objects
+// and the next pagination key if applicable.
+func (bucket OSSBucket) ParsePage(data []byte) ([]Object, string, error) {
+	var objects []Object
+	var page OSSBucketPage
+	var token string
+	if bytes.Contains(data, []byte("<Error>")) {
+		var ossErr OSSError
+		if err := xml.Unmarshal(data, &ossErr); err == nil {
+			return nil, "", ossErr
+		}
+	}
+	err := xml.Unmarshal(data, &page)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, k := range page.Contents {
+		objects = append(objects, Object{
+			Key:          decodeKey(page.EncodingType, k.Key),
+			Size:         parseSize(k.Size),
+			LastModified: k.LastModified,
+			ETag:         k.ETag,
+		})
+	}
+	if page.IsTruncated {
+		switch {
+		case page.NextMarker != "":
+			token = decodeKey(page.EncodingType, page.NextMarker)
+		case len(objects) > 0:
+			token = objects[len(objects)-1].Key
+		default:
+			return objects, "", ErrEmptyTruncatedPage
+		}
+	}
+	return objects, token, nil
+}
@@ -3,10 +3,27 @@ package bucket
 import (
 	"encoding/xml"
 	"fmt"
+	"net/http"
 	"net/url"
 	"strings"
+	"sync"
+
+	"github.com/shellhazard/bucketbuster/internal/auth"
+)
+
+// S3 listing dialects.
+const (
+	s3ListUnknown = 0 // Not yet detected.
+	s3ListV1      = 1 // ListObjects, paginated with marker.
+	s3ListV2      = 2 // ListObjectsV2, paginated with continuation-token.
 )
 
+// Listing dialects detected for each S3 endpoint, keyed by host.
+var s3Dialects = struct {
+	sync.Mutex
+	hosts map[string]int
+}{hosts: map[string]int{}}
+
 // Type S3Bucket represents a generic S3 compatible storage bucket.
 type S3Bucket struct {
 	// The base URL of the bucket.
@@ -14,19 +31,66 @@ type S3Bucket struct {
 
 	// The generated name of the bucket.
 	name string
+
+	// Query parameters from the input URL to send with every request,
+	// such as vendor access tokens.
+	query url.Values
+
+	// Set to list every version of each object with ListObjectVersions.
+	versions bool
+
+	// The region reported by the provider, if the bucket was redirected.
+	region string
+
+	// Listing state shared between copies of the bucket.
+	listing *s3Listing
+}
+
+// Pagination strategies used to recover a stalled S3 listing.
+const (
+	s3StrategyDefault    = 0 // Continuation tokens, or markers for ListObjects.
+	s3StrategyStartAfter = 1 // ListObjectsV2 with start-after set to the last key.
+	s3StrategyMarker     = 2 // ListObjects with marker set to the last key.
+)
+
+// Type s3Listing tracks the continuation token most recently returned by
+// ParsePage, so PageURL can tell it apart from a key to start after, along
+// with the pagination strategy in use.
+type s3Listing struct {
+	sync.Mutex
+	continuation string
+	strategy     int
+}
+
+// Query parameters that control S3 listings. These are dropped from input
+// URLs since the paginator sets them itself.
+var s3ListingParameters = map[string]bool{
+	"list-type": true, "start-after": true, "continuation-token": true,
+	"marker": true, "max-keys": true, "prefix": true, "delimiter": true,
+	"encoding-type": true, "fetch-owner": true, "versions": true,
+	"key-marker": true, "version-id-marker": true,
 }
 
 // Type S3BucketPage is a helper type for storing XML data.
 type S3BucketPage struct {
-	XMLName     xml.Name `xml:"ListBucketResult"`
-	Text        string   `xml:",chardata"`
-	Xmlns       string   `xml:"xmlns,attr"`
-	Name        string   `xml:"Name"`
-	Prefix      string   `xml:"Prefix"`
-	Marker      string   `xml:"Marker"`
-	MaxKeys     string   `xml:"MaxKeys"`
-	IsTruncated bool     `xml:"IsTruncated"`
-	Contents    []struct {
+	XMLName      xml.Name `xml:"ListBucketResult"`
+	Text         string   `xml:",chardata"`
+	Xmlns        string   `xml:"xmlns,attr"`
+	Name         string   `xml:"Name"`
+	Prefix       string   `xml:"Prefix"`
+	Marker       string   `xml:"Marker"`
+	NextMarker   string   `xml:"NextMarker"`
+	MaxKeys      string   `xml:"MaxKeys"`
+	EncodingType string   `xml:"EncodingType"`
+	IsTruncated  bool     `xml:"IsTruncated"`
+
+	// ListObjectsV2 fields
+	KeyCount              string `xml:"KeyCount"`
+	ContinuationToken     string `xml:"ContinuationToken"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	StartAfter            string `xml:"StartAfter"`
+
+	Contents []struct {
 		Text         string `xml:",chardata"`
 		Key          string `xml:"Key"`
 		LastModified string `xml:"LastModified"`
@@ -36,13 +100,114 @@ type S3BucketPage struct {
 	} `xml:"Contents"`
 }
 
+// Type S3VersionsPage is a helper type for storing XML data returned by
+// ListObjectVersions. Versions and delete markers are interleaved in key
+// order, so both are collected into Entries to keep that order.
+type S3VersionsPage struct {
+	XMLName             xml.Name `xml:"ListVersionsResult"`
+	Name                string   `xml:"Name"`
+	Prefix              string   `xml:"Prefix"`
+	KeyMarker           string   `xml:"KeyMarker"`
+	VersionIdMarker     string   `xml:"VersionIdMarker"`
+	NextKeyMarker       string   `xml:"NextKeyMarker"`
+	NextVersionIdMarker string   `xml:"NextVersionIdMarker"`
+	MaxKeys             string   `xml:"MaxKeys"`
+	EncodingType        string   `xml:"EncodingType"`
+	IsTruncated         bool     `xml:"IsTruncated"`
+
+	Entries []struct {
+		XMLName      xml.Name // Version or DeleteMarker
+		Key          string   `xml:"Key"`
+		VersionId    string   `xml:"VersionId"`
+		IsLatest     bool     `xml:"IsLatest"`
+		LastModified string   `xml:"LastModified"`
+		ETag         string   `xml:"ETag"`
+		Size         string   `xml:"Size"`
+		StorageClass string   `xml:"StorageClass"`
+	} `xml:",any"`
+}
+
+// Type S3Service represents the root of an S3 compatible endpoint, which
+// lists every bucket it hosts if misconfigured to allow it.
+type S3Service struct {
+	// The URL of the endpoint root.
+	baseURL string
+
+	// Query parameters from the input URL to send with every request.
+	query url.Values
+}
+
+// Type S3ServicePage is a helper type for storing XML data returned by
+// ListBuckets.
+type S3ServicePage struct {
+	XMLName xml.Name `xml:"ListAllMyBucketsResult"`
+	Owner   struct {
+		ID          string `xml:"ID"`
+		DisplayName string `xml:"DisplayName"`
+	} `xml:"Owner"`
+	Buckets struct {
+		Bucket []struct {
+			Name         string `xml:"Name"`
+			CreationDate string `xml:"CreationDate"`
+		} `xml:"Bucket"`
+	} `xml:"Buckets"`
+	ContinuationToken string `xml:"ContinuationToken"`
+}
+
+// Type S3Error is an error document returned by S3.
+type S3Error struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string   `xml:"Code"`
+	Message  string   `xml:"Message"`
+	Bucket   string   `xml:"Bucket"`
+	Endpoint string   `xml:"Endpoint"`
+	Region   string   `xml:"Region"`
+}
+
+func (e S3Error) Error() string {
+	return fmt.Sprintf("S3 error %s: %s", e.Code, e.Message)
+}
+
+// Error codes returned when a bucket is accessed through the wrong regional endpoint.
+var s3RedirectCodes = map[string]bool{
+	"PermanentRedirect": true, "TemporaryRedirect": true,
+	"AuthorizationHeaderMalformed": true, "IllegalLocationConstraintException": true,
+}
+
 func NewS3Bucket(baseURL string, name string) S3Bucket {
 	return S3Bucket{
 		baseURL: baseURL,
 		name:    name,
+		listing: &s3Listing{},
 	}
 }
 
+// Returns a copy of the bucket that sends the query parameters with every
+// request. Listing parameters are ignored.
+func (bucket S3Bucket) WithQuery(query url.Values) S3Bucket {
+	bucket.query = url.Values{}
+	for k, v := range query {
+		if !s3ListingParameters[strings.ToLower(k)] {
+			bucket.query[k] = v
+		}
+	}
+	return bucket
+}
+
+// Returns a copy of the bucket that lists every version of each object,
+// including delete markers, with ListObjectVersions.
+func (bucket S3Bucket) WithVersions() Bucket {
+	bucket.versions = true
+	bucket.listing = &s3Listing{}
+	return bucket
+}
+
+// Returns a copy of the bucket without any preserved query parameters.
+func (bucket S3Bucket) WithoutCredentials() Bucket {
+	bucket.query = nil
+	return bucket
+}
+
 // Returns the name of the bucket, which is the URL for a generic S3 bucket.
 func (bucket S3Bucket) Name() string {
 	return bucket.name
@@ -53,28 +218,152 @@ func (bucket S3Bucket) URL() string {
 	return bucket.baseURL
 }
 
+// Returns the region the bucket is hosted in. This is the region reported
+// by the provider if the bucket was redirected, otherwise the region named
+// by an AWS regional hostname. Returns an empty string if it isn't known.
+func (bucket S3Bucket) Region() string {
+	if bucket.region != "" {
+		return bucket.region
+	}
+	return s3HostRegion(bucket.host())
+}
+
+// Returns a copy of the bucket for the endpoint a response redirects to, if
+// the bucket was accessed through the wrong regional endpoint. AWS endpoints
+// are rebuilt for the region in the x-amz-bucket-region header, other
+// providers use the endpoint in the error document.
+func (bucket S3Bucket) Redirect(statusCode int, header http.Header, body []byte) (Bucket, bool) {
+	var e S3Error
+	xml.Unmarshal(body, &e)
+	if statusCode != http.StatusMovedPermanently && !s3RedirectCodes[e.Code] {
+		return nil, false
+	}
+	region := header.Get("x-amz-bucket-region")
+	if region == "" {
+		region = e.Region
+	}
+
+	urlData, err := url.Parse(bucket.baseURL)
+	if err != nil {
+		return nil, false
+	}
+	switch {
+	case region != "" && strings.HasSuffix(strings.ToLower(urlData.Hostname()), ".amazonaws.com"):
+		host, ok := s3RegionalHost(urlData.Hostname(), region)
+		if !ok {
+			return nil, false
+		}
+		urlData.Host = host
+	case e.Endpoint != "":
+		urlData.Host = e.Endpoint
+	default:
+		return nil, false
+	}
+	if urlData.String() == bucket.baseURL {
+		return nil, false
+	}
+	bucket.baseURL = urlData.String()
+	bucket.region = region
+	return bucket, true
+}
+
 // Returns the URL pointing to the position in the bucket indicated by the pagination key.
+// ListObjectsV2 is requested unless the endpoint is known to only support
+// ListObjects. Keys other than the last continuation token, such as one
+// given by the user, are treated as the key to start after.
 func (bucket S3Bucket) PageURL(paginationKey string) string {
-	if paginationKey == "" {
-		return bucket.URL()
+	if bucket.versions {
+		return bucket.versionsPageURL(paginationKey)
+	}
+	dialect := bucket.dialect()
+	continuation, strategy := bucket.state()
+	if strategy == s3StrategyMarker {
+		dialect = s3ListV1
+	}
+	var target string
+	switch {
+	case paginationKey == "" && dialect == s3ListV1:
+		target = fmt.Sprintf("%s?encoding-type=url", bucket.URL())
+	case paginationKey == "":
+		target = fmt.Sprintf("%s?list-type=2&encoding-type=url", bucket.URL())
+	case dialect == s3ListV1:
+		target = fmt.Sprintf("%s?encoding-type=url&marker=%s", bucket.URL(), url.QueryEscape(paginationKey))
+	case paginationKey == continuation && strategy == s3StrategyDefault:
+		target = fmt.Sprintf("%s?list-type=2&encoding-type=url&continuation-token=%s", bucket.URL(), url.QueryEscape(paginationKey))
+	default:
+		target = fmt.Sprintf("%s?list-type=2&encoding-type=url&start-after=%s", bucket.URL(), url.QueryEscape(paginationKey))
+	}
+	return appendQuery(target, bucket.query.Encode())
+}
+
+// Returns the URL pointing to a page of object versions. The pagination
+// key is either the markers most recently returned by ParsePage, or a key
+// to start listing after.
+func (bucket S3Bucket) versionsPageURL(paginationKey string) string {
+	continuation, _ := bucket.state()
+	var target string
+	switch {
+	case paginationKey == "":
+		target = fmt.Sprintf("%s?versions&encoding-type=url", bucket.URL())
+	case paginationKey == continuation:
+		target = fmt.Sprintf("%s?versions&encoding-type=url&%s", bucket.URL(), paginationKey)
+	default:
+		target = fmt.Sprintf("%s?versions&encoding-type=url&key-marker=%s", bucket.URL(), url.QueryEscape(paginationKey))
 	}
-	return fmt.Sprintf("%s?list-type=2&start-after=%s", bucket.URL(), url.QueryEscape(paginationKey))
+	return appendQuery(target, bucket.query.Encode())
 }
 
 // Returns the URL used to fetch the resource with the specified key.
-// TODO: Support extracting download key from metadata.
 func (bucket S3Bucket) ResourceURL(key string) string {
 	burl := bucket.URL()
 	if !strings.HasSuffix(burl, "/") {
 		burl = fmt.Sprintf("%s/", burl)
 	}
-	return fmt.Sprintf("%s%s", burl, url.QueryEscape(key))
+	return appendQuery(fmt.Sprintf("%s%s", burl, escapePath(key)), bucket.query.Encode())
+}
+
+// Returns the bucket configuration endpoints to probe for public access.
+func (bucket S3Bucket) AuditChecks() []AuditCheck {
+	var checks []AuditCheck
+	for _, name := range []string{"acl", "policy", "cors", "website", "versioning", "logging"} {
+		checks = append(checks, AuditCheck{
+			Name: name,
+			URL:  appendQuery(fmt.Sprintf("%s?%s", bucket.URL(), name), bucket.query.Encode()),
+		})
+	}
+	return checks
+}
+
+// Returns the requests used to write a canary object. Uploads set
+// If-None-Match so an existing object is never overwritten.
+func (bucket S3Bucket) WriteProbe(key string) WriteProbe {
+	resourceURL := bucket.ResourceURL(key)
+	return WriteProbe{
+		ExistsURL:     resourceURL,
+		UploadMethod:  http.MethodPut,
+		UploadURL:     resourceURL,
+		UploadHeaders: map[string]string{"If-None-Match": "*", "Content-Type": "text/plain"},
+		Conditional:   true,
+		ReadURL:       resourceURL,
+		DeleteURL:     resourceURL,
+	}
 }
 
-// Parses a response to a page request and returns a slice of the keys
+// Returns the URL used to fetch the object, pinned to its version if known.
+func (bucket S3Bucket) ObjectURL(o Object) string {
+	if o.VersionID == "" {
+		return bucket.ResourceURL(o.Key)
+	}
+	return appendQuery(bucket.ResourceURL(o.Key), url.Values{"versionId": {o.VersionID}}.Encode())
+}
+
+// Parses a response to a page request and returns a slice of the objects
 // and the next pagination key if applicable.
-func (bucket S3Bucket) ParsePage(data []byte) ([]string, string, error) {
-	var keys []string
+func (bucket S3Bucket) ParsePage(data []byte) ([]Object, string, error) {
+	if bucket.versions {
+		return bucket.parseVersionsPage(data)
+	}
+	var objects []Object
 	var page S3BucketPage
 	var token string
 	err := xml.Unmarshal(data, &page)
@@ -82,10 +371,242 @@ func (bucket S3Bucket) ParsePage(data []byte) ([]string, string, error) {
 		return nil, "", err
 	}
 	for _, k := range page.Contents {
-		keys = append(keys, k.Key)
+		objects = append(objects, Object{
+			Key:          decodeKey(page.EncodingType, k.Key),
+			Size:         parseSize(k.Size),
+			LastModified: k.LastModified,
+			ETag:         k.ETag,
+		})
 	}
+
+	// Providers that don't support ListObjectsV2 ignore list-type and
+	// respond with a ListObjects page, which has no KeyCount.
+	if page.KeyCount != "" {
+		bucket.setDialect(s3ListV2)
+	} else {
+		bucket.setDialect(s3ListV1)
+	}
+
 	if page.IsTruncated {
-		token = keys[len(keys)-1]
+		_, strategy := bucket.state()
+		switch {
+		case strategy == s3StrategyDefault && page.NextContinuationToken != "":
+			token = page.NextContinuationToken
+			bucket.setContinuation(token)
+		case strategy == s3StrategyDefault && page.NextMarker != "":
+			token = decodeKey(page.EncodingType, page.NextMarker)
+		case len(objects) > 0:
+			token = objects[len(objects)-1].Key
+		default:
+			return objects, "", ErrEmptyTruncatedPage
+		}
+	}
+	return objects, token, nil
+}
+
+// Parses a ListObjectVersions page. The next pagination key holds both the
+// key and version ID markers, since a single key may have more versions
+// than fit on a page.
+func (bucket S3Bucket) parseVersionsPage(data []byte) ([]Object, string, error) {
+	var objects []Object
+	var page S3VersionsPage
+	var token string
+	err := xml.Unmarshal(data, &page)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, k := range page.Entries {
+		if k.XMLName.Local != "Version" && k.XMLName.Local != "DeleteMarker" {
+			continue
+		}
+		objects = append(objects, Object{
+			Key:          decodeKey(page.EncodingType, k.Key),
+			Size:         parseSize(k.Size),
+			LastModified: k.LastModified,
+			ETag:         k.ETag,
+			VersionID:    k.VersionId,
+			IsLatest:     k.IsLatest,
+			DeleteMarker: k.XMLName.Local == "DeleteMarker",
+		})
+	}
+
+	if page.IsTruncated {
+		switch {
+		case page.NextKeyMarker != "" && page.NextVersionIdMarker != "":
+			token = url.Values{
+				"key-marker":        {decodeKey(page.EncodingType, page.NextKeyMarker)},
+				"version-id-marker": {page.NextVersionIdMarker},
+			}.Encode()
+			bucket.setContinuation(token)
+		case page.NextKeyMarker != "":
+			token = decodeKey(page.EncodingType, page.NextKeyMarker)
+		default:
+			return objects, "", ErrEmptyTruncatedPage
+		}
+	}
+	return objects, token, nil
+}
+
+// Switches to the next pagination strategy after a listing stalls and
+// returns the key to resume from. Continuation tokens are abandoned in
+// favour of start-after, and then ListObjects markers. Version listings
+// have no other strategy to fall back to.
+func (bucket S3Bucket) Recover(lastKey string) (string, bool) {
+	if lastKey == "" || bucket.listing == nil || bucket.versions {
+		return "", false
+	}
+	bucket.listing.Lock()
+	defer bucket.listing.Unlock()
+	switch {
+	case bucket.listing.strategy == s3StrategyDefault && bucket.dialect() != s3ListV1:
+		bucket.listing.strategy = s3StrategyStartAfter
+	case bucket.listing.strategy != s3StrategyMarker:
+		bucket.listing.strategy = s3StrategyMarker
+	default:
+		return "", false
+	}
+	return lastKey, true
+}
+
+// Returns the listing dialect detected for the bucket's endpoint.
+func (bucket S3Bucket) dialect() int {
+	s3Dialects.Lock()
+	defer s3Dialects.Unlock()
+	return s3Dialects.hosts[bucket.host()]
+}
+
+// Records the listing dialect supported by the bucket's endpoint.
+func (bucket S3Bucket) setDialect(dialect int) {
+	s3Dialects.Lock()
+	defer s3Dialects.Unlock()
+	s3Dialects.hosts[bucket.host()] = dialect
+}
+
+// Returns the continuation token most recently returned by ParsePage and
+// the pagination strategy in use.
+func (bucket S3Bucket) state() (string, int) {
+	if bucket.listing == nil {
+		return "", s3StrategyDefault
+	}
+	bucket.listing.Lock()
+	defer bucket.listing.Unlock()
+	return bucket.listing.continuation, bucket.listing.strategy
+}
+
+// Records the continuation token returned by ParsePage.
+func (bucket S3Bucket) setContinuation(token string) {
+	if bucket.listing == nil {
+		return
+	}
+	bucket.listing.Lock()
+	defer bucket.listing.Unlock()
+	bucket.listing.continuation = token
+}
+
+// Returns the host of the bucket's endpoint.
+func (bucket S3Bucket) host() string {
+	urlData, err := url.Parse(bucket.baseURL)
+	if err != nil {
+		return bucket.baseURL
+	}
+	return strings.ToLower(urlData.Host)
+}
+
+func NewS3Service(baseURL string) S3Service {
+	return S3Service{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+// Returns a copy of the service that sends the query parameters with every
+// request, including those made to its buckets.
+func (service S3Service) WithQuery(query url.Values) S3Service {
+	service.query = query
+	return service
+}
+
+// Returns the name of the service, which is the endpoint host.
+func (service S3Service) Name() string {
+	return service.host()
+}
+
+// Returns the URL of the endpoint root.
+func (service S3Service) URL() string {
+	return fmt.Sprintf("%s/", service.baseURL)
+}
+
+// Returns the URL pointing to the position in the bucket listing indicated
+// by the pagination key.
+func (service S3Service) ListURL(paginationKey string) string {
+	target := service.URL()
+	if paginationKey != "" {
+		target = fmt.Sprintf("%s?continuation-token=%s", target, url.QueryEscape(paginationKey))
+	}
+	return appendQuery(target, service.query.Encode())
+}
+
+// Parses a ListBuckets response and returns a path-style bucket for each
+// bucket listed. Any other document, such as the listing of a
+// virtual-hosted bucket at the same URL, is an error.
+func (service S3Service) ParseList(data []byte) ([]Bucket, string, error) {
+	var buckets []Bucket
+	var page S3ServicePage
+	err := xml.Unmarshal(data, &page)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, b := range page.Buckets.Bucket {
+		baseURL := fmt.Sprintf("%s/%s", service.baseURL, url.PathEscape(b.Name))
+		name := fmt.Sprintf("%s-%s", service.host(), b.Name)
+		buckets = append(buckets, NewS3Bucket(baseURL, name).WithQuery(service.query))
+	}
+	return buckets, page.ContinuationToken, nil
+}
+
+// Returns no candidates, since bucket names on arbitrary endpoints can't be guessed.
+func (service S3Service) Candidates() []Bucket {
+	return nil
+}
+
+// Returns true since an endpoint root may also be a virtual-hosted bucket.
+func (service S3Service) Ambiguous() bool {
+	return true
+}
+
+// Returns the host of the endpoint.
+func (service S3Service) host() string {
+	urlData, err := url.Parse(service.baseURL)
+	if err != nil {
+		return service.baseURL
+	}
+	return strings.ToLower(urlData.Host)
+}
+
+// Returns the region named by an AWS hostname, e.g. eu-west-1 for
+// example.s3.eu-west-1.amazonaws.com.
+func s3HostRegion(host string) string {
+	if !strings.HasSuffix(strings.ToLower(host), ".amazonaws.com") {
+		return ""
+	}
+	region, _ := auth.HostRegion(host)
+	return region
+}
+
+// Returns the AWS host for the same bucket in another region, keeping the
+// virtual-hosted or path-style form, e.g. example.s3.amazonaws.com becomes
+// example.s3.eu-west-1.amazonaws.com.
+func s3RegionalHost(host string, region string) (string, bool) {
+	fragments := strings.Split(strings.ToLower(host), ".")
+	for i, fragment := range fragments {
+		if fragment != "amazonaws" {
+			continue
+		}
+		for j := i - 1; j >= 0; j-- {
+			if fragments[j] == "s3" || strings.HasPrefix(fragments[j], "s3-") {
+				regional := append(append([]string{}, fragments[:j]...), "s3", region)
+				return strings.Join(append(regional, fragments[i:]...), "."), true
+			}
+		}
 	}
-	return keys, token, nil
+	return "", false
 }
@@ -3,8 +3,10 @@ package bucket
 import (
 	"encoding/xml"
 	"fmt"
+	"net/http"
 	"net/url"
 	"strings"
+	"time"
 )
 
 // Type AzureStorageBucket represents an Azure Storage bucket.
@@ -14,8 +16,21 @@ type AzureStorageBucket struct {
 
 	// The name of the container
 	container string
+
+	// The shared access signature appended to requests, if any.
+	sas SAS
+
+	// The datasets to include in listings, e.g. snapshots or versions.
+	include []string
 }
 
+// The storage service version requested when listing with include options.
+// Anonymous requests otherwise use a version that predates versions and tags.
+const azureListVersion = "2021-08-06"
+
+// Datasets that can be included in container listings.
+var AzureIncludeOptions = []string{"snapshots", "versions", "deleted", "metadata", "tags"}
+
 // Type AzureStorageBucketPage is a helper type for storing XML data.
 type AzureStorageBucketPage struct {
 	XMLName         xml.Name `xml:"EnumerationResults"`
@@ -25,9 +40,25 @@ type AzureStorageBucketPage struct {
 	Blobs           struct {
 		Text string `xml:",chardata"`
 		Blob []struct {
-			Text       string `xml:",chardata"`
-			Name       string `xml:"Name"`
-			Url        string `xml:"Url"`
+			Text             string `xml:",chardata"`
+			Name             string `xml:"Name"`
+			Url              string `xml:"Url"`
+			Snapshot         string `xml:"Snapshot"`
+			VersionId        string `xml:"VersionId"`
+			IsCurrentVersion bool   `xml:"IsCurrentVersion"`
+			Deleted          bool   `xml:"Deleted"`
+			Metadata         struct {
+				Items []struct {
+					XMLName xml.Name
+					Value   string `xml:",chardata"`
+				} `xml:",any"`
+			} `xml:"Metadata"`
+			Tags struct {
+				Tag []struct {
+					Key   string `xml:"Key"`
+					Value string `xml:"Value"`
+				} `xml:"TagSet>Tag"`
+			} `xml:"Tags"`
 			Properties struct {
 				Text               string `xml:",chardata"`
 				LastModified       string `xml:"Last-Modified"`
@@ -42,12 +73,106 @@ type AzureStorageBucketPage struct {
 				BlobType           string `xml:"BlobType"`
 				LeaseStatus        string `xml:"LeaseStatus"`
 				LeaseState         string `xml:"LeaseState"`
+				DeletedTime        string `xml:"DeletedTime"`
 			} `xml:"Properties"`
 		} `xml:"Blob"`
 	} `xml:"Blobs"`
 	NextMarker string `xml:"NextMarker"`
 }
 
+// Type AzureStorageAccount represents an Azure storage account hosting
+// multiple containers.
+type AzureStorageAccount struct {
+	// The name of the account
+	accountname string
+
+	// The shared access signature appended to requests, if any.
+	sas SAS
+}
+
+// Type AzureStorageAccountPage is a helper type for storing XML data.
+type AzureStorageAccountPage struct {
+	XMLName         xml.Name `xml:"EnumerationResults"`
+	Text            string   `xml:",chardata"`
+	ServiceEndpoint string   `xml:"ServiceEndpoint,attr"`
+	Containers      struct {
+		Text      string `xml:",chardata"`
+		Container []struct {
+			Text string `xml:",chardata"`
+			Name string `xml:"Name"`
+		} `xml:"Container"`
+	} `xml:"Containers"`
+	NextMarker string `xml:"NextMarker"`
+}
+
+// Container names commonly used for public content, probed when the
+// account's container listing isn't public.
+var azureContainerWordlist = []string{
+	"$web", "$root", "public", "images", "img", "media", "assets", "static",
+	"content", "uploads", "files", "downloads", "documents", "docs", "data",
+	"backup", "backups", "logs", "videos", "test", "dev", "staging", "prod",
+}
+
+func NewAzureStorageAccount(accountname string) AzureStorageAccount {
+	return AzureStorageAccount{
+		accountname: accountname,
+	}
+}
+
+// Returns a copy of the account that appends the shared access signature
+// to every request, including those made to its containers.
+func (account AzureStorageAccount) WithSAS(sas SAS) AzureStorageAccount {
+	account.sas = sas
+	return account
+}
+
+// Returns problems with the account's shared access signature, if any.
+func (account AzureStorageAccount) Warnings() []string {
+	return account.sas.Warnings(time.Now())
+}
+
+// Returns the name of the account.
+func (account AzureStorageAccount) Name() string {
+	return account.accountname
+}
+
+// Returns the URL of the account's blob endpoint.
+func (account AzureStorageAccount) URL() string {
+	return fmt.Sprintf("https://%s.blob.core.windows.net/", account.accountname)
+}
+
+// Returns the URL pointing to the position in the container listing indicated by the pagination key.
+func (account AzureStorageAccount) ListURL(paginationKey string) string {
+	if paginationKey == "" {
+		return account.sas.appendTo(fmt.Sprintf("%s?comp=list", account.URL()))
+	}
+	return account.sas.appendTo(fmt.Sprintf("%s?comp=list&marker=%s", account.URL(), url.QueryEscape(paginationKey)))
+}
+
+// Parses a container listing and returns a bucket for each container
+// and the next pagination key if applicable.
+func (account AzureStorageAccount) ParseList(data []byte) ([]Bucket, string, error) {
+	var buckets []Bucket
+	var page AzureStorageAccountPage
+	err := xml.Unmarshal(data, &page)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, c := range page.Containers.Container {
+		buckets = append(buckets, NewAzureStorageBucket(account.accountname, c.Name).WithSAS(account.sas))
+	}
+	return buckets, page.NextMarker, nil
+}
+
+// Returns a bucket for each commonly used container name.
+func (account AzureStorageAccount) Candidates() []Bucket {
+	var buckets []Bucket
+	for _, name := range azureContainerWordlist {
+		buckets = append(buckets, NewAzureStorageBucket(account.accountname, name).WithSAS(account.sas))
+	}
+	return buckets
+}
+
 func NewAzureStorageBucket(accountname string, container string) AzureStorageBucket {
 	return AzureStorageBucket{
 		accountname: accountname,
@@ -55,6 +180,67 @@ func NewAzureStorageBucket(accountname string, container string) AzureStorageBuc
 	}
 }
 
+// Returns a copy of the bucket that appends the shared access signature
+// to every page and resource URL.
+func (bucket AzureStorageBucket) WithSAS(sas SAS) AzureStorageBucket {
+	bucket.sas = sas
+	return bucket
+}
+
+// Returns a copy of the bucket that includes the datasets in listings.
+// Valid options are listed in AzureIncludeOptions.
+func (bucket AzureStorageBucket) WithInclude(include []string) (AzureStorageBucket, error) {
+	for _, option := range include {
+		valid := false
+		for _, known := range AzureIncludeOptions {
+			valid = valid || option == known
+		}
+		if !valid {
+			return bucket, fmt.Errorf("unknown Azure include option %q", option)
+		}
+		if !bucket.includes(option) {
+			bucket.include = append(append([]string{}, bucket.include...), option)
+		}
+	}
+	return bucket, nil
+}
+
+// Returns a copy of the bucket that lists every version of each blob.
+func (bucket AzureStorageBucket) WithVersions() Bucket {
+	b, _ := bucket.WithInclude([]string{"versions"})
+	return b
+}
+
+// Returns the headers sent with each page request. Include options
+// require a newer service version than anonymous requests default to.
+func (bucket AzureStorageBucket) RequestHeaders() map[string]string {
+	if len(bucket.include) == 0 {
+		return nil
+	}
+	return map[string]string{"x-ms-version": azureListVersion}
+}
+
+// Returns a copy of the bucket without its shared access signature.
+func (bucket AzureStorageBucket) WithoutCredentials() Bucket {
+	bucket.sas = SAS{}
+	return bucket
+}
+
+// Returns problems with the bucket's shared access signature, if any.
+func (bucket AzureStorageBucket) Warnings() []string {
+	return bucket.sas.Warnings(time.Now())
+}
+
+// Returns true if the dataset is included in listings.
+func (bucket AzureStorageBucket) includes(option string) bool {
+	for _, included := range bucket.include {
+		if included == option {
+			return true
+		}
+	}
+	return false
+}
+
 // Returns the name of the bucket, which is the URL for a generic S3 bucket.
 func (bucket AzureStorageBucket) Name() string {
 	return fmt.Sprintf("%s-%s", bucket.accountname, bucket.container)
@@ -67,26 +253,71 @@ func (bucket AzureStorageBucket) URL() string {
 
 // Returns the URL pointing to the position in the bucket indicated by the pagination key.
 func (bucket AzureStorageBucket) PageURL(paginationKey string) string {
-	if paginationKey == "" {
-		return fmt.Sprintf("%s?restype=container&comp=list", bucket.URL())
+	target := fmt.Sprintf("%s?restype=container&comp=list", bucket.URL())
+	if len(bucket.include) > 0 {
+		target = fmt.Sprintf("%s&include=%s", target, strings.Join(bucket.include, ","))
+	}
+	if paginationKey != "" {
+		target = fmt.Sprintf("%s&marker=%s", target, url.QueryEscape(paginationKey))
 	}
-	return fmt.Sprintf("%s?restype=container&comp=list&marker=%s", bucket.URL(), url.QueryEscape(paginationKey))
+	return bucket.sas.appendTo(target)
 }
 
 // Returns the URL used to fetch the resource with the specified key.
-// TODO: Support extracting download key from metadata.
 func (bucket AzureStorageBucket) ResourceURL(key string) string {
 	burl := bucket.URL()
 	if !strings.HasSuffix(burl, "/") {
 		burl = fmt.Sprintf("%s/", burl)
 	}
-	return fmt.Sprintf("%s%s", burl, url.QueryEscape(key))
+	return bucket.sas.appendTo(fmt.Sprintf("%s%s", burl, escapePath(key)))
+}
+
+// Returns the container configuration endpoints to probe for public access.
+// Both report the container's public access level in response headers.
+func (bucket AzureStorageBucket) AuditChecks() []AuditCheck {
+	return []AuditCheck{
+		{Name: "properties", URL: bucket.sas.appendTo(fmt.Sprintf("%s?restype=container", bucket.URL())), Headers: true},
+		{Name: "acl", URL: bucket.sas.appendTo(fmt.Sprintf("%s?restype=container&comp=acl", bucket.URL())), Headers: true},
+	}
+}
+
+// Returns the requests used to write a canary blob with Put Blob. Uploads
+// set If-None-Match so an existing blob is never overwritten.
+func (bucket AzureStorageBucket) WriteProbe(key string) WriteProbe {
+	resourceURL := bucket.ResourceURL(key)
+	return WriteProbe{
+		ExistsURL:    resourceURL,
+		UploadMethod: http.MethodPut,
+		UploadURL:    resourceURL,
+		UploadHeaders: map[string]string{
+			"If-None-Match":  "*",
+			"Content-Type":   "text/plain",
+			"x-ms-blob-type": "BlockBlob",
+			"x-ms-version":   azureListVersion,
+		},
+		Conditional: true,
+		ReadURL:     resourceURL,
+		DeleteURL:   resourceURL,
+	}
+}
+
+// Returns the URL used to fetch the object, addressing the specific
+// snapshot or version if it has one.
+func (bucket AzureStorageBucket) ObjectURL(o Object) string {
+	resourceURL := bucket.ResourceURL(o.Key)
+	switch {
+	case o.Snapshot != "":
+		return appendQuery(resourceURL, url.Values{"snapshot": {o.Snapshot}}.Encode())
+	case o.VersionID != "":
+		return appendQuery(resourceURL, url.Values{"versionid": {o.VersionID}}.Encode())
+	}
+	return resourceURL
 }
 
-// Parses a response to a page request and returns a slice of the keys
+// Parses a response to a page request and returns a slice of the objects
 // and the next pagination key if applicable.
-func (bucket AzureStorageBucket) ParsePage(data []byte) ([]string, string, error) {
-	var keys []string
+func (bucket AzureStorageBucket) ParsePage(data []byte) ([]Object, string, error) {
+	var objects []Object
 	var page AzureStorageBucketPage
 	var token string
 	err := xml.Unmarshal(data, &page)
@@ -94,10 +325,34 @@ func (bucket AzureStorageBucket) ParsePage(data []byte) ([]string, string, error
 		return nil, "", err
 	}
 	for _, k := range page.Blobs.Blob {
-		keys = append(keys, k.Name)
+		o := Object{
+			Key:          k.Name,
+			Size:         parseSize(k.Properties.ContentLength),
+			LastModified: k.Properties.LastModified,
+			ETag:         k.Properties.Etag,
+			ContentType:  k.Properties.ContentType,
+			MD5Hash:      k.Properties.ContentMD5,
+			Snapshot:     k.Snapshot,
+			VersionID:    k.VersionId,
+			IsLatest:     k.IsCurrentVersion,
+			Deleted:      k.Deleted,
+		}
+		for _, m := range k.Metadata.Items {
+			if o.Metadata == nil {
+				o.Metadata = map[string]string{}
+			}
+			o.Metadata[m.XMLName.Local] = m.Value
+		}
+		for _, t := range k.Tags.Tag {
+			if o.Tags == nil {
+				o.Tags = map[string]string{}
+			}
+			o.Tags[t.Key] = t.Value
+		}
+		objects = append(objects, o)
 	}
 	if page.NextMarker != "" {
 		token = page.NextMarker
 	}
-	return keys, token, nil
+	return objects, token, nil
 }
@@ -0,0 +1,100 @@
+package bucket
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// Type AzureDataLakeBucket represents a filesystem in an Azure storage
+// account with a hierarchical namespace, listed through the dfs endpoint.
+type AzureDataLakeBucket struct {
+	// The name of the account
+	accountname string
+
+	// The name of the filesystem
+	filesystem string
+}
+
+// Type AzureDataLakeBucketPage is a helper type for storing JSON data.
+// The service encodes booleans and numbers as strings.
+type AzureDataLakeBucketPage struct {
+	Paths []struct {
+		Name          string `json:"name"`
+		IsDirectory   string `json:"isDirectory"`
+		ContentLength string `json:"contentLength"`
+		LastModified  string `json:"lastModified"`
+		ETag          string `json:"etag"`
+		Owner         string `json:"owner"`
+		Group         string `json:"group"`
+		Permissions   string `json:"permissions"`
+	} `json:"paths"`
+}
+
+func NewAzureDataLakeBucket(accountname string, filesystem string) AzureDataLakeBucket {
+	return AzureDataLakeBucket{
+		accountname: accountname,
+		filesystem:  filesystem,
+	}
+}
+
+// Returns the name of the bucket.
+func (bucket AzureDataLakeBucket) Name() string {
+	return fmt.Sprintf("%s-%s", bucket.accountname, bucket.filesystem)
+}
+
+// Returns the URL of the bucket.
+func (bucket AzureDataLakeBucket) URL() string {
+	return fmt.Sprintf("https://%s.dfs.core.windows.net/%s", bucket.accountname, bucket.filesystem)
+}
+
+// Returns the URL pointing to the position in the bucket indicated by the pagination key.
+func (bucket AzureDataLakeBucket) PageURL(paginationKey string) string {
+	if paginationKey == "" {
+		return fmt.Sprintf("%s?resource=filesystem&recursive=true", bucket.URL())
+	}
+	return fmt.Sprintf("%s?resource=filesystem&recursive=true&continuation=%s", bucket.URL(), url.QueryEscape(paginationKey))
+}
+
+// Returns the headers sent with each page request. The dfs endpoint
+// rejects path listings made with the service version anonymous requests
+// default to.
+func (bucket AzureDataLakeBucket) RequestHeaders() map[string]string {
+	return map[string]string{"x-ms-version": azureListVersion}
+}
+
+// Returns the URL used to fetch the resource with the specified key.
+func (bucket AzureDataLakeBucket) ResourceURL(key string) string {
+	return fmt.Sprintf("%s/%s", bucket.URL(), escapePath(key))
+}
+
+// Returns true since recursive listings are ordered by directory rather than key.
+func (bucket AzureDataLakeBucket) Unordered() bool {
+	return true
+}
+
+// Returns the name of the response header containing the next pagination key.
+func (bucket AzureDataLakeBucket) PaginationHeader() string {
+	return "x-ms-continuation"
+}
+
+// Parses a response to a page request and returns a slice of the objects.
+// The next pagination key is returned in the x-ms-continuation header.
+func (bucket AzureDataLakeBucket) ParsePage(data []byte) ([]Object, string, error) {
+	var objects []Object
+	var page AzureDataLakeBucketPage
+	err := json.Unmarshal(data, &page)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, k := range page.Paths {
+		objects = append(objects, Object{
+			Key:          k.Name,
+			Size:         parseSize(k.ContentLength),
+			LastModified: k.LastModified,
+			ETag:         k.ETag,
+			Directory:    k.IsDirectory == "true",
+		})
+	}
+	return objects, "", nil
+}
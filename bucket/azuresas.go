@@ -0,0 +1,113 @@
+package bucket
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Query parameters that make up an Azure shared access signature.
+var sasParameters = map[string]bool{
+	"sv": true, "ss": true, "srt": true, "sp": true, "se": true, "st": true,
+	"spr": true, "sip": true, "sr": true, "sig": true, "si": true, "sdd": true,
+	"ses": true, "skoid": true, "sktid": true, "skt": true, "ske": true,
+	"sks": true, "skv": true, "saoid": true, "suoid": true, "scid": true,
+	"rscc": true, "rscd": true, "rsce": true, "rscl": true, "rsct": true,
+}
+
+// Layouts accepted by Azure for SAS start and expiry times.
+var sasTimeLayouts = []string{
+	"2006-01-02T15:04:05Z",
+	"2006-01-02T15:04:05.0000000Z",
+	"2006-01-02T15:04Z",
+	"2006-01-02",
+}
+
+// Type SAS is an Azure shared access signature taken from a query string.
+type SAS struct {
+	values url.Values
+}
+
+// Extracts a shared access signature from a query string, ignoring any
+// parameters that aren't part of it. Returns an empty SAS if the query
+// doesn't contain one, or an error if it is incomplete.
+func ParseSAS(rawQuery string) (SAS, error) {
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return SAS{}, err
+	}
+	values := url.Values{}
+	for k, v := range query {
+		if sasParameters[k] {
+			values[k] = v
+		}
+	}
+	if len(values) == 0 {
+		return SAS{}, nil
+	}
+	if values.Get("sig") == "" {
+		return SAS{}, errors.New("SAS token is missing its signature (sig)")
+	}
+	return SAS{values: values}, nil
+}
+
+// Returns true if no signature is present.
+func (sas SAS) Empty() bool {
+	return len(sas.values) == 0
+}
+
+// Returns the signature encoded as a query string.
+func (sas SAS) Encode() string {
+	return sas.values.Encode()
+}
+
+// Returns the time the signature expires, if it can be determined.
+func (sas SAS) Expiry() (time.Time, bool) {
+	se := sas.values.Get("se")
+	for _, layout := range sasTimeLayouts {
+		t, err := time.Parse(layout, se)
+		if err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Returns problems that will likely prevent the signature from being
+// used to list a container at the specified time.
+func (sas SAS) Warnings(now time.Time) []string {
+	var warnings []string
+	if sas.Empty() {
+		return nil
+	}
+
+	if expiry, ok := sas.Expiry(); ok {
+		if now.After(expiry) {
+			warnings = append(warnings, fmt.Sprintf("SAS token expired at %s", expiry.Format(time.RFC3339)))
+		}
+	} else {
+		warnings = append(warnings, fmt.Sprintf("SAS token has an unreadable expiry time (se=%s)", sas.values.Get("se")))
+	}
+
+	if !strings.Contains(sas.values.Get("sp"), "l") {
+		warnings = append(warnings, fmt.Sprintf("SAS token lacks list permission (sp=%s)", sas.values.Get("sp")))
+	}
+
+	// Service SAS tokens carry a resource type, account SAS tokens carry
+	// services instead. Container and directory tokens can list, blob,
+	// version and snapshot tokens can't.
+	if sr := sas.values.Get("sr"); sr == "b" || sr == "bv" || sr == "bs" {
+		warnings = append(warnings, fmt.Sprintf("SAS token is scoped to a single resource rather than a container (sr=%s)", sr))
+	}
+	if ss := sas.values.Get("ss"); ss != "" && !strings.Contains(ss, "b") {
+		warnings = append(warnings, fmt.Sprintf("SAS token doesn't grant access to the blob service (ss=%s)", ss))
+	}
+	return warnings
+}
+
+// Appends the signature to a URL, which may already have a query string.
+func (sas SAS) appendTo(target string) string {
+	return appendQuery(target, sas.Encode())
+}
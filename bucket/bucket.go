@@ -4,13 +4,19 @@ package bucket
 import (
 	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/shellhazard/bucketbuster/internal/utils"
 )
 
+// Returned by ParsePage when a provider claims a page is truncated but gives
+// no way to request the next one.
+var ErrEmptyTruncatedPage = errors.New("page is truncated but contains no keys or pagination key")
+
 // Interface Bucket defines a deconstructed storage bucket.
 type Bucket interface {
 	// Returns the name of the bucket.
@@ -25,8 +31,372 @@ type Bucket interface {
 	// Returns a URL to download a specific resource in the bucket.
 	ResourceURL(string) string
 
-	// Parses page data and returns a list of keys found and the next pagination key if applicable.
-	ParsePage([]byte) ([]string, string, error)
+	// Parses page data and returns a list of objects found and the next pagination key if applicable.
+	ParsePage([]byte) ([]Object, string, error)
+}
+
+// Interface HeaderPaginatedBucket is implemented by buckets whose next
+// pagination key is returned in a response header rather than the page body.
+type HeaderPaginatedBucket interface {
+	Bucket
+
+	// Returns the name of the response header containing the next pagination key.
+	PaginationHeader() string
+}
+
+// Interface RequestHeaderBucket is implemented by buckets that send extra
+// headers with each page request, such as the service version required for
+// newer listing options.
+type RequestHeaderBucket interface {
+	Bucket
+
+	// Returns the headers to send with each page request.
+	RequestHeaders() map[string]string
+}
+
+// Interface MetadataBucket is implemented by buckets whose listings omit
+// object metadata that can be fetched separately for each object.
+type MetadataBucket interface {
+	Bucket
+
+	// Returns a URL to fetch the metadata of a specific object.
+	MetadataURL(string) string
+
+	// Parses object metadata and returns the object with it merged in.
+	ParseMetadata([]byte, Object) (Object, error)
+}
+
+// Interface PrefixBucket is implemented by buckets that can list a single
+// level of a folder hierarchy, such as buckets whose root listing only
+// shows folders.
+type PrefixBucket interface {
+	Bucket
+
+	// Returns a copy of the bucket that lists the objects directly within
+	// the prefix, with folders returned as directories.
+	WithPrefix(string) Bucket
+}
+
+// Interface RegionalBucket is implemented by buckets hosted in a known region.
+type RegionalBucket interface {
+	Bucket
+
+	// Returns the region the bucket is hosted in, or an empty string if it isn't known.
+	Region() string
+}
+
+// Interface RedirectBucket is implemented by buckets that can be rebuilt for
+// another endpoint when the provider redirects a listing, e.g. to the
+// bucket's region.
+type RedirectBucket interface {
+	Bucket
+
+	// Returns a copy of the bucket for the endpoint an unsuccessful response
+	// redirects to, or false if the response isn't a redirect.
+	Redirect(int, http.Header, []byte) (Bucket, bool)
+}
+
+// Type AuditCheck describes a configuration endpoint of a bucket, such as
+// its access control list, that may be readable anonymously.
+type AuditCheck struct {
+	// The name of the configuration, e.g. acl or policy.
+	Name string
+
+	// The URL of the configuration endpoint.
+	URL string
+
+	// Set if the configuration is returned in response headers rather than the body.
+	Headers bool
+}
+
+// Interface AuditableBucket is implemented by buckets with configuration
+// endpoints worth probing for public access.
+type AuditableBucket interface {
+	Bucket
+
+	// Returns the configuration endpoints to probe.
+	AuditChecks() []AuditCheck
+}
+
+// Type WriteProbe describes how to write, read back and delete a canary
+// object to test whether a bucket is publicly writable.
+type WriteProbe struct {
+	// The URL requested with HEAD to check the key doesn't already exist.
+	ExistsURL string
+
+	// The method, URL and headers used to upload the object.
+	UploadMethod  string
+	UploadURL     string
+	UploadHeaders map[string]string
+
+	// Set if the upload is rejected when the key already exists, so an
+	// inconclusive existence check can't lead to an overwrite.
+	Conditional bool
+
+	// The URL used to read the object back.
+	ReadURL string
+
+	// The URL requested with DELETE to remove the object.
+	DeleteURL string
+}
+
+// Interface WriteProbeBucket is implemented by buckets that support
+// anonymous uploads.
+type WriteProbeBucket interface {
+	Bucket
+
+	// Returns the requests used to write a canary object with the specified key.
+	WriteProbe(string) WriteProbe
+}
+
+// Interface Warner is implemented by buckets and services that can report
+// problems with how they were specified, such as an expired access token.
+type Warner interface {
+	// Returns a description of each problem found.
+	Warnings() []string
+}
+
+// Interface CredentialBucket is implemented by buckets that carry credentials,
+// such as access tokens, in the URLs they build.
+type CredentialBucket interface {
+	Bucket
+
+	// Returns a copy of the bucket that builds URLs without credentials.
+	WithoutCredentials() Bucket
+}
+
+// Interface RecoverableBucket is implemented by buckets that can resume a
+// stalled listing with a different pagination strategy. Listings of other
+// buckets, whose providers only accept their own opaque tokens, stop with
+// a paginator.StallError instead.
+type RecoverableBucket interface {
+	Bucket
+
+	// Returns a pagination key that resumes the listing after the specified
+	// key, or false if there are no other strategies to try.
+	Recover(string) (string, bool)
+}
+
+// Interface UnorderedBucket is implemented by buckets whose listings aren't
+// sorted by key, such as recursive directory listings.
+type UnorderedBucket interface {
+	Bucket
+
+	// Returns true if keys may be listed out of order.
+	Unordered() bool
+}
+
+// Interface VersionedBucket is implemented by buckets that can list every
+// version of each object rather than only the current one.
+type VersionedBucket interface {
+	Bucket
+
+	// Returns a copy of the bucket that lists all object versions.
+	WithVersions() Bucket
+}
+
+// Interface ObjectURLBucket is implemented by buckets that need more than
+// the key to address an object, such as a specific version of it.
+type ObjectURLBucket interface {
+	Bucket
+
+	// Returns a URL to download the specific object.
+	ObjectURL(Object) string
+}
+
+// Returns the URL used to download an object from a bucket, addressing the
+// specific version of the object where the bucket supports it.
+func ObjectURL(b Bucket, o Object) string {
+	if ob, ok := b.(ObjectURLBucket); ok {
+		return ob.ObjectURL(o)
+	}
+	return b.ResourceURL(o.Key)
+}
+
+// Interface Service defines a storage endpoint that hosts multiple buckets,
+// such as an Azure storage account.
+type Service interface {
+	// Returns the name of the service.
+	Name() string
+
+	// Returns the URL of the service.
+	URL() string
+
+	// Returns a URL to access a page of the bucket listing.
+	ListURL(string) string
+
+	// Parses a bucket listing and returns the buckets found and the next pagination key if applicable.
+	ParseList([]byte) ([]Bucket, string, error)
+
+	// Returns buckets worth probing individually when the listing isn't public.
+	Candidates() []Bucket
+}
+
+// Interface AmbiguousService is implemented by services whose URL may
+// instead point at a single bucket, such as an S3 endpoint root that could
+// also be a virtual-hosted bucket.
+type AmbiguousService interface {
+	Service
+
+	// Returns true if the URL should be treated as a bucket when no buckets are discovered.
+	Ambiguous() bool
+}
+
+// Type Object describes a single entry in a bucket listing. Fields other
+// than Key are only populated when the provider returns them.
+type Object struct {
+	Key          string `json:"key"`
+	Size         int64  `json:"size,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	ETag         string `json:"etag,omitempty"`
+	ContentType  string `json:"contentType,omitempty"`
+	MD5Hash      string `json:"md5Hash,omitempty"`
+	CRC32C       string `json:"crc32c,omitempty"`
+	Generation   string `json:"generation,omitempty"`
+	MediaLink    string `json:"mediaLink,omitempty"`
+	Directory    bool   `json:"directory,omitempty"`
+	VersionID    string `json:"versionId,omitempty"`
+	IsLatest     bool   `json:"isLatest,omitempty"`
+	DeleteMarker bool   `json:"deleteMarker,omitempty"`
+	Snapshot     string `json:"snapshot,omitempty"`
+	Deleted      bool   `json:"deleted,omitempty"`
+	TimeCreated  string `json:"timeCreated,omitempty"`
+
+	// A token granting access to the object, such as a Firebase download token.
+	DownloadToken string `json:"downloadToken,omitempty"`
+
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+}
+
+// Parses a size reported by a provider, returning 0 if it is missing or malformed.
+func parseSize(s string) int64 {
+	size, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return size
+}
+
+// Decodes a key from a listing requested with encoding-type=url. XML
+// listings are requested URL encoded so keys containing characters that
+// are invalid in XML can still be parsed. Keys are returned unchanged if
+// the provider ignored the encoding type.
+func decodeKey(encodingType string, key string) string {
+	if !strings.EqualFold(encodingType, "url") {
+		return key
+	}
+	decoded, err := url.QueryUnescape(key)
+	if err != nil {
+		return key
+	}
+	return decoded
+}
+
+// Escapes a key for use in a URL path, leaving the separating slashes
+// intact. Used by providers that address objects by their full path, such
+// as S3, GCS XML and Azure blobs.
+func escapePath(key string) string {
+	return escapeKey(key, false)
+}
+
+// Escapes a key as a single URL path segment, encoding slashes as %2F.
+// Used by providers that expect the object name as one segment, such as
+// the Firebase and GCS JSON APIs.
+func escapeSegment(key string) string {
+	return escapeKey(key, true)
+}
+
+// Percent-encodes every byte of the key except RFC 3986 unreserved
+// characters. Characters that url.PathEscape leaves alone, such as "+"
+// and "&", are encoded too since some providers decode them differently.
+func escapeKey(key string, encodeSlash bool) string {
+	var builder strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			builder.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&builder, "%%%02X", c)
+	}
+	return builder.String()
+}
+
+// Appends an encoded query string to a URL, which may already have one.
+func appendQuery(target string, rawQuery string) string {
+	if rawQuery == "" {
+		return target
+	}
+	if strings.Contains(target, "?") {
+		return fmt.Sprintf("%s&%s", target, rawQuery)
+	}
+	return fmt.Sprintf("%s?%s", target, rawQuery)
+}
+
+// Attempts to fingerprint a service hosting multiple buckets based on the URL.
+// Returns nil if the URL doesn't point at the root of a known service.
+func ParseServiceURL(input string) (Service, error) {
+	urlData, err := url.Parse(input)
+	if err != nil {
+		return nil, err
+	}
+
+	if urlData.Host == "" {
+		return nil, errors.New("Invalid URL (missing scheme?)")
+	}
+
+	// Parse fragments
+	pathFragments := utils.CleanStringSlice(strings.Split(urlData.Path, "/"))
+	hostFragments := utils.CleanStringSlice(strings.Split(urlData.Host, "."))
+
+	// Fingerprint Azure storage accounts
+	matched, err := regexp.Match(`(?i)[A-Z\d-]{3,63}\.blob\.core\.windows\.net`, []byte(input))
+	if err != nil {
+		return nil, err
+	}
+	if matched {
+		if len(pathFragments) == 0 && len(hostFragments) >= 5 {
+			sas, err := ParseSAS(urlData.RawQuery)
+			if err != nil {
+				return nil, err
+			}
+			return NewAzureStorageAccount(hostFragments[len(hostFragments)-5]).WithSAS(sas), nil
+		}
+	}
+
+	// Fingerprint the roots of generic S3 endpoints, which may list every
+	// bucket they host. These can't be told apart from virtual-hosted
+	// buckets without listing them, unless the host already names a bucket.
+	if len(pathFragments) == 0 && !s3VirtualHosted(urlData.Hostname()) {
+		b, err := ParseURL(input)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := b.(S3Bucket); ok {
+			query := urlData.Query()
+			urlData.RawQuery = ""
+			urlData.Path = ""
+			return NewS3Service(urlData.String()).WithQuery(query), nil
+		}
+	}
+
+	return nil, nil
+}
+
+// Returns true if the host is a virtual-hosted bucket on an endpoint whose
+// hostnames are known, such as example.s3.eu-west-1.amazonaws.com or
+// example.nyc3.digitaloceanspaces.com, rather than the endpoint root.
+func s3VirtualHosted(host string) bool {
+	host = strings.ToLower(host)
+	fragments := strings.Split(host, ".")
+	for i, fragment := range fragments {
+		if i > 0 && (fragment == "s3" || strings.HasPrefix(fragment, "s3-")) {
+			return true
+		}
+	}
+	return strings.HasSuffix(host, ".digitaloceanspaces.com") && len(fragments) > 3
 }
 
 // Attempts to fingerprint the kind of bucket based on the URL.
@@ -69,7 +439,33 @@ func ParseURL(input string) (Bucket, error) {
 			// Extract name
 			reversedHostFragments := hostFragments
 			utils.ReverseAny(reversedHostFragments)
-			return NewAzureStorageBucket(reversedHostFragments[4], pathFragments[0]), nil
+			sas, err := ParseSAS(urlData.RawQuery)
+			if err != nil {
+				return nil, err
+			}
+			return NewAzureStorageBucket(reversedHostFragments[4], pathFragments[0]).WithSAS(sas), nil
+		}
+	}
+
+	// Fingerprint Azure Data Lake Gen2 filesystems
+	matched, err = regexp.Match(`(?i)[A-Z\d-]{3,63}\.dfs\.core\.windows\.net`, []byte(input))
+	if err != nil {
+		return nil, err
+	}
+	if matched {
+		if len(pathFragments) >= 1 && len(hostFragments) >= 5 {
+			return NewAzureDataLakeBucket(hostFragments[len(hostFragments)-5], pathFragments[0]), nil
+		}
+	}
+
+	// Fingerprint Google Storage JSON API buckets
+	matched, err = regexp.Match(`(?i)(www|storage)\.googleapis\.com\/storage\/v\d\/b\/[A-Z\d-\._]{3,222}`, []byte(input))
+	if err != nil {
+		return nil, err
+	}
+	if matched {
+		if len(pathFragments) >= 4 && pathFragments[0] == "storage" && pathFragments[2] == "b" {
+			return NewGoogleStorageJSONBucket(pathFragments[3]), nil
 		}
 	}
 
@@ -99,7 +495,62 @@ func ParseURL(input string) (Bucket, error) {
 		}
 	}
 
+	// Fingerprint Alibaba Cloud OSS buckets
+	matched, err = regexp.Match(`(?i)([A-Z\d-]{3,63}\.)?oss-[A-Z\d-]+\.aliyuncs\.com`, []byte(input))
+	if err != nil {
+		return nil, err
+	}
+	if matched {
+		host := strings.ToLower(urlData.Hostname())
+		if strings.HasPrefix(host, "oss-") {
+			// Name in path
+			if len(pathFragments) >= 1 {
+				return NewOSSBucket(pathFragments[0], host), nil
+			}
+		} else if len(hostFragments) >= 4 {
+			// Name in subdomain
+			return NewOSSBucket(hostFragments[0], strings.Join(hostFragments[1:], ".")), nil
+		}
+	}
+
+	// Fingerprint Oracle Cloud Object Storage buckets
+	matched, err = regexp.Match(`(?i)objectstorage\.[A-Z\d-]+\.(oraclecloud\.com|oci\.customer-oci\.com)(\/p\/[^\/]+)?\/n\/[^\/]+\/b\/[^\/?#]+`, []byte(input))
+	if err != nil {
+		return nil, err
+	}
+	if matched {
+		// Pre-authenticated requests are prefixed with /p/<token>
+		par := ""
+		fragments := pathFragments
+		if len(fragments) >= 2 && fragments[0] == "p" {
+			par = fragments[1]
+			fragments = fragments[2:]
+		}
+		if len(fragments) >= 4 && fragments[0] == "n" && fragments[2] == "b" {
+			return NewOCIBucket(urlData.Host, fragments[1], fragments[3], par), nil
+		}
+	}
+
+	// Fingerprint OpenStack Swift containers
+	matched, err = regexp.Match(`(?i)\/v\d(\.\d)?\/AUTH_[A-Z\d_-]+\/[^\/?#]+`, []byte(input))
+	if err != nil {
+		return nil, err
+	}
+	if matched {
+		for i := 1; i < len(pathFragments)-1; i++ {
+			if strings.HasPrefix(strings.ToUpper(pathFragments[i]), "AUTH_") {
+				// Keep any prefix before the version, e.g. /swift/v1 on Ceph
+				containerPath := strings.Join(pathFragments[:i+2], "/")
+				baseURL := fmt.Sprintf("%s://%s/%s", urlData.Scheme, urlData.Host, containerPath)
+				name := fmt.Sprintf("%s-%s-%s", urlData.Host, pathFragments[i], pathFragments[i+1])
+				return NewSwiftBucket(baseURL, name), nil
+			}
+		}
+	}
+
 	// Otherwise, assume it's a generic generic S3 bucket
+	// Keep the query string separately so it can be merged into each request
+	query := urlData.Query()
 	urlData.RawQuery = ""
 
 	// Build bucket name
@@ -108,5 +559,5 @@ func ParseURL(input string) (Bucket, error) {
 	if pathstring != "" {
 		name = fmt.Sprintf("%s-%s", urlData.Host, pathstring)
 	}
-	return NewS3Bucket(urlData.String(), name), nil
+	return NewS3Bucket(urlData.String(), name).WithQuery(query), nil
 }
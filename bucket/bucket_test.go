@@ -0,0 +1,875 @@
+package bucket
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode"
+)
+
+// Keys that have broken listings or resource URLs in the past.
+var pathologicalKeys = []string{
+	"plain.txt",
+	"nested/folder/file.txt",
+	"with space.txt",
+	"unicode/日本語 ファイル.pdf",
+	"emoji-🪣.png",
+	"percent%20literal.txt",
+	"percent%2Fslash",
+	"plus+sign.txt",
+	"hash#fragment.txt",
+	"question?mark=1&x=2",
+	"new\nline.txt",
+	"tab\tchar.txt",
+	"ampersand&<angle>.xml",
+	"trailing/",
+	"control\x01char.bin",
+}
+
+// Returns true if the key contains characters that can't appear in XML 1.0,
+// even escaped, and so only survive listings that URL encode keys.
+func xmlUnsafe(key string) bool {
+	for _, r := range key {
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' {
+			return true
+		}
+	}
+	return false
+}
+
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// Renders a URL encoded S3-style listing, as returned for encoding-type=url.
+func encodedListing(root string, keys []string, extra string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<%s><EncodingType>url</EncodingType>%s<IsTruncated>false</IsTruncated>", root, extra)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "<Contents><Key>%s</Key></Contents>", url.QueryEscape(k))
+	}
+	fmt.Fprintf(&b, "</%s>", root)
+	return []byte(b.String())
+}
+
+func mustJSON(t *testing.T, v interface{}) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+type providerCase struct {
+	name string
+	b    Bucket
+
+	// Renders a listing page containing the keys.
+	page func(t *testing.T, keys []string) []byte
+
+	// The query string expected on resource URLs.
+	resourceQuery string
+
+	// Set if the listing format can't carry XML-unsafe keys.
+	skipXMLUnsafe bool
+}
+
+func providerCases() []providerCase {
+	return []providerCase{
+		{
+			name: "s3",
+			b:    NewS3Bucket("https://example.s3.amazonaws.com", "example"),
+			page: func(t *testing.T, keys []string) []byte {
+				return encodedListing("ListBucketResult", keys, fmt.Sprintf("<KeyCount>%d</KeyCount>", len(keys)))
+			},
+		},
+		{
+			name: "google-xml",
+			b:    NewGoogleStorageBucket("example"),
+			page: func(t *testing.T, keys []string) []byte {
+				return encodedListing("ListBucketResult", keys, "")
+			},
+		},
+		{
+			name: "oss",
+			b:    NewOSSBucket("example", "oss-cn-hangzhou.aliyuncs.com"),
+			page: func(t *testing.T, keys []string) []byte {
+				return encodedListing("ListBucketResult", keys, "")
+			},
+		},
+		{
+			name: "azure",
+			b:    NewAzureStorageBucket("account", "container"),
+			page: func(t *testing.T, keys []string) []byte {
+				var b strings.Builder
+				b.WriteString("<EnumerationResults><Blobs>")
+				for _, k := range keys {
+					fmt.Fprintf(&b, "<Blob><Name>%s</Name></Blob>", xmlEscape(k))
+				}
+				b.WriteString("</Blobs><NextMarker /></EnumerationResults>")
+				return []byte(b.String())
+			},
+			skipXMLUnsafe: true,
+		},
+		{
+			name: "google-json",
+			b:    NewGoogleStorageJSONBucket("example"),
+			page: func(t *testing.T, keys []string) []byte {
+				var items []map[string]string
+				for _, k := range keys {
+					items = append(items, map[string]string{"name": k})
+				}
+				return mustJSON(t, map[string]interface{}{"items": items})
+			},
+			resourceQuery: "alt=media",
+		},
+		{
+			name: "firestore",
+			b:    NewFirestoreBucket("example.appspot.com"),
+			page: func(t *testing.T, keys []string) []byte {
+				var items []map[string]string
+				for _, k := range keys {
+					items = append(items, map[string]string{"name": k, "bucket": "example.appspot.com"})
+				}
+				return mustJSON(t, map[string]interface{}{"items": items})
+			},
+			resourceQuery: "alt=media",
+		},
+		{
+			name: "azure-datalake",
+			b:    NewAzureDataLakeBucket("account", "filesystem"),
+			page: func(t *testing.T, keys []string) []byte {
+				var paths []map[string]string
+				for _, k := range keys {
+					paths = append(paths, map[string]string{"name": k, "contentLength": "1"})
+				}
+				return mustJSON(t, map[string]interface{}{"paths": paths})
+			},
+		},
+		{
+			name: "swift",
+			b:    NewSwiftBucket("https://storage101.dfw1.clouddrive.com/v1/AUTH_account/container", "swift"),
+			page: func(t *testing.T, keys []string) []byte {
+				var items []map[string]interface{}
+				for _, k := range keys {
+					items = append(items, map[string]interface{}{"name": k, "bytes": 1})
+				}
+				return mustJSON(t, items)
+			},
+		},
+		{
+			name: "oci",
+			b:    NewOCIBucket("objectstorage.us-ashburn-1.oraclecloud.com", "namespace", "example", ""),
+			page: func(t *testing.T, keys []string) []byte {
+				var objects []map[string]string
+				for _, k := range keys {
+					objects = append(objects, map[string]string{"name": k})
+				}
+				return mustJSON(t, map[string]interface{}{"objects": objects})
+			},
+		},
+	}
+}
+
+func TestParsePagePathologicalKeys(t *testing.T) {
+	for _, pc := range providerCases() {
+		t.Run(pc.name, func(t *testing.T) {
+			var keys []string
+			for _, k := range pathologicalKeys {
+				if pc.skipXMLUnsafe && xmlUnsafe(k) {
+					continue
+				}
+				keys = append(keys, k)
+			}
+
+			objects, _, err := pc.b.ParsePage(pc.page(t, keys))
+			if err != nil {
+				t.Fatalf("ParsePage failed: %s", err)
+			}
+			if len(objects) != len(keys) {
+				t.Fatalf("ParsePage returned %d objects, want %d", len(objects), len(keys))
+			}
+			for i, o := range objects {
+				if o.Key != keys[i] {
+					t.Errorf("ParsePage returned key %q, want %q", o.Key, keys[i])
+				}
+			}
+		})
+	}
+}
+
+func TestResourceURLPathologicalKeys(t *testing.T) {
+	for _, pc := range providerCases() {
+		t.Run(pc.name, func(t *testing.T) {
+			for _, k := range pathologicalKeys {
+				resourceURL := pc.b.ResourceURL(k)
+				for _, r := range resourceURL {
+					if unicode.IsSpace(r) || unicode.IsControl(r) || r > unicode.MaxASCII {
+						t.Errorf("ResourceURL(%q) = %q contains unescaped %q", k, resourceURL, r)
+						break
+					}
+				}
+				urlData, err := url.Parse(resourceURL)
+				if err != nil {
+					t.Errorf("ResourceURL(%q) = %q doesn't parse: %s", k, resourceURL, err)
+					continue
+				}
+				if urlData.Fragment != "" {
+					t.Errorf("ResourceURL(%q) = %q has a fragment", k, resourceURL)
+				}
+				if urlData.RawQuery != pc.resourceQuery {
+					t.Errorf("ResourceURL(%q) = %q has query %q, want %q", k, resourceURL, urlData.RawQuery, pc.resourceQuery)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeKey(t *testing.T) {
+	tests := []struct {
+		encodingType string
+		key          string
+		want         string
+	}{
+		{"url", "with+space%2Bplus", "with space+plus"},
+		{"url", "new%0Aline", "new\nline"},
+		{"URL", "%E6%97%A5", "日"},
+		{"", "raw+key%20", "raw+key%20"},
+		{"url", "bad%zzescape", "bad%zzescape"},
+	}
+	for _, test := range tests {
+		if got := decodeKey(test.encodingType, test.key); got != test.want {
+			t.Errorf("decodeKey(%q, %q) = %q, want %q", test.encodingType, test.key, got, test.want)
+		}
+	}
+}
+
+func TestResourceURL(t *testing.T) {
+	sas, err := ParseSAS("sv=2021-08-06&sp=rl&sig=c2lnbmF0dXJl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		b    Bucket
+		key  string
+		want string
+	}{
+		{"s3 virtual-hosted", NewS3Bucket("https://example.s3.amazonaws.com", "example"), "dir/file name.txt", "https://example.s3.amazonaws.com/dir/file%20name.txt"},
+		{"s3 path-style", NewS3Bucket("https://s3.amazonaws.com/example", "example"), "dir/file name.txt", "https://s3.amazonaws.com/example/dir/file%20name.txt"},
+		{"s3 reserved", NewS3Bucket("https://example.s3.amazonaws.com/", "example"), "a+b&c=d?#.txt", "https://example.s3.amazonaws.com/a%2Bb%26c%3Dd%3F%23.txt"},
+		{"s3 query", NewS3Bucket("https://example.s3.amazonaws.com", "example").WithQuery(url.Values{"X-Amz-Signature": {"abc"}}), "100%.txt", "https://example.s3.amazonaws.com/100%25.txt?X-Amz-Signature=abc"},
+		{"google-xml", NewGoogleStorageBucket("example"), "dir/日本.txt", "https://example.storage.googleapis.com/dir/%E6%97%A5%E6%9C%AC.txt"},
+		{"google-xml reserved", NewGoogleStorageBucket("example"), "a+b c", "https://example.storage.googleapis.com/a%2Bb%20c"},
+		{"google-json", NewGoogleStorageJSONBucket("example"), "dir/file name.txt", "https://storage.googleapis.com/download/storage/v1/b/example/o/dir%2Ffile%20name.txt?alt=media"},
+		{"firestore", NewFirestoreBucket("example.appspot.com"), "images/a+b.png", "https://firebasestorage.googleapis.com/v0/b/example.appspot.com/o/images%2Fa%2Bb.png?alt=media"},
+		{"firestore unicode", NewFirestoreBucket("example.appspot.com"), "日本/x y", "https://firebasestorage.googleapis.com/v0/b/example.appspot.com/o/%E6%97%A5%E6%9C%AC%2Fx%20y?alt=media"},
+		{"azure", NewAzureStorageBucket("account", "container"), "dir/file name.txt", "https://account.blob.core.windows.net/container/dir/file%20name.txt"},
+		{"azure sas", NewAzureStorageBucket("account", "container").WithSAS(sas), "a+b#c", "https://account.blob.core.windows.net/container/a%2Bb%23c?sig=c2lnbmF0dXJl&sp=rl&sv=2021-08-06"},
+		{"azure-datalake", NewAzureDataLakeBucket("account", "filesystem"), "dir/a&b.csv", "https://account.dfs.core.windows.net/filesystem/dir/a%26b.csv"},
+		{"oss", NewOSSBucket("example", "oss-cn-hangzhou.aliyuncs.com"), "dir/file name.txt", "https://example.oss-cn-hangzhou.aliyuncs.com/dir/file%20name.txt"},
+		{"oci", NewOCIBucket("objectstorage.us-ashburn-1.oraclecloud.com", "namespace", "example", ""), "dir/a+b.txt", "https://objectstorage.us-ashburn-1.oraclecloud.com/n/namespace/b/example/o/dir/a%2Bb.txt"},
+		{"oci par", NewOCIBucket("objectstorage.us-ashburn-1.oraclecloud.com", "namespace", "example", "token"), "x y", "https://objectstorage.us-ashburn-1.oraclecloud.com/p/token/n/namespace/b/example/o/x%20y"},
+		{"swift", NewSwiftBucket("https://storage101.dfw1.clouddrive.com/v1/AUTH_account/container", "swift"), "dir/file?.txt", "https://storage101.dfw1.clouddrive.com/v1/AUTH_account/container/dir/file%3F.txt"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.b.ResourceURL(test.key); got != test.want {
+				t.Errorf("ResourceURL(%q) = %q, want %q", test.key, got, test.want)
+			}
+		})
+	}
+}
+
+func TestEscapeKey(t *testing.T) {
+	tests := []struct {
+		key         string
+		encodeSlash bool
+		want        string
+	}{
+		{"a/b/c", false, "a/b/c"},
+		{"a/b/c", true, "a%2Fb%2Fc"},
+		{"-_.~", false, "-_.~"},
+		{"a b+c", false, "a%20b%2Bc"},
+		{"!$&'()*,;=:@", false, "%21%24%26%27%28%29%2A%2C%3B%3D%3A%40"},
+		{"\x00\n", false, "%00%0A"},
+		{"é", false, "%C3%A9"},
+	}
+	for _, test := range tests {
+		if got := escapeKey(test.key, test.encodeSlash); got != test.want {
+			t.Errorf("escapeKey(%q, %v) = %q, want %q", test.key, test.encodeSlash, got, test.want)
+		}
+	}
+}
+
+func TestS3Versions(t *testing.T) {
+	b := NewS3Bucket("https://example.s3.amazonaws.com", "example").WithVersions()
+
+	if got, want := b.PageURL(""), "https://example.s3.amazonaws.com?versions&encoding-type=url"; got != want {
+		t.Errorf("PageURL(\"\") = %q, want %q", got, want)
+	}
+
+	page := `<ListVersionsResult><EncodingType>url</EncodingType><IsTruncated>true</IsTruncated>
+		<NextKeyMarker>b+file</NextKeyMarker><NextVersionIdMarker>v2</NextVersionIdMarker>
+		<Version><Key>a</Key><VersionId>v1</VersionId><IsLatest>true</IsLatest><Size>3</Size></Version>
+		<DeleteMarker><Key>b+file</Key><VersionId>v3</VersionId><IsLatest>true</IsLatest></DeleteMarker>
+		<Version><Key>b+file</Key><VersionId>v2</VersionId><IsLatest>false</IsLatest><Size>5</Size></Version>
+	</ListVersionsResult>`
+	objects, token, err := b.ParsePage([]byte(page))
+	if err != nil {
+		t.Fatalf("ParsePage failed: %s", err)
+	}
+	want := []Object{
+		{Key: "a", VersionID: "v1", IsLatest: true, Size: 3},
+		{Key: "b file", VersionID: "v3", IsLatest: true, DeleteMarker: true},
+		{Key: "b file", VersionID: "v2", Size: 5},
+	}
+	if len(objects) != len(want) {
+		t.Fatalf("ParsePage returned %d objects, want %d", len(objects), len(want))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(objects[i], want[i]) {
+			t.Errorf("ParsePage returned %+v, want %+v", objects[i], want[i])
+		}
+	}
+
+	if got, want := b.PageURL(token), "https://example.s3.amazonaws.com?versions&encoding-type=url&key-marker=b+file&version-id-marker=v2"; got != want {
+		t.Errorf("PageURL(%q) = %q, want %q", token, got, want)
+	}
+	if got, want := b.PageURL("start key"), "https://example.s3.amazonaws.com?versions&encoding-type=url&key-marker=start+key"; got != want {
+		t.Errorf("PageURL(\"start key\") = %q, want %q", got, want)
+	}
+	if got, want := ObjectURL(b, objects[2]), "https://example.s3.amazonaws.com/b%20file?versionId=v2"; got != want {
+		t.Errorf("ObjectURL = %q, want %q", got, want)
+	}
+}
+
+func TestS3Dialects(t *testing.T) {
+	// Dialects are shared per host, so each case uses its own endpoint
+	t.Run("v2 continuation token", func(t *testing.T) {
+		b := NewS3Bucket("https://v2.s3.amazonaws.com", "v2")
+		if got, want := b.PageURL(""), "https://v2.s3.amazonaws.com?list-type=2&encoding-type=url"; got != want {
+			t.Errorf("PageURL(\"\") = %q, want %q", got, want)
+		}
+		_, token, err := b.ParsePage([]byte(`<ListBucketResult><KeyCount>1</KeyCount><IsTruncated>true</IsTruncated>
+			<NextContinuationToken>1ueGcxLPRx1Tr/XYExHnhbYLgveDs2J/wm36Hy4vbOwM=</NextContinuationToken>
+			<Contents><Key>a</Key></Contents></ListBucketResult>`))
+		if err != nil {
+			t.Fatalf("ParsePage failed: %s", err)
+		}
+		if token != "1ueGcxLPRx1Tr/XYExHnhbYLgveDs2J/wm36Hy4vbOwM=" {
+			t.Fatalf("ParsePage returned token %q, want the continuation token", token)
+		}
+		if got, want := b.PageURL(token), "https://v2.s3.amazonaws.com?list-type=2&encoding-type=url&continuation-token=1ueGcxLPRx1Tr%2FXYExHnhbYLgveDs2J%2Fwm36Hy4vbOwM%3D"; got != want {
+			t.Errorf("PageURL(%q) = %q, want %q", token, got, want)
+		}
+	})
+
+	t.Run("v1 reply to v2 request", func(t *testing.T) {
+		b := NewS3Bucket("https://legacy.example.com/bucket", "legacy")
+		if got, want := b.PageURL(""), "https://legacy.example.com/bucket?list-type=2&encoding-type=url"; got != want {
+			t.Errorf("PageURL(\"\") = %q, want %q", got, want)
+		}
+		// The endpoint ignored list-type and returned a ListObjects page
+		_, token, err := b.ParsePage([]byte(`<ListBucketResult><IsTruncated>true</IsTruncated>
+			<Contents><Key>a</Key></Contents><Contents><Key>b c</Key></Contents></ListBucketResult>`))
+		if err != nil {
+			t.Fatalf("ParsePage failed: %s", err)
+		}
+		if token != "b c" {
+			t.Fatalf("ParsePage returned token %q, want the last key", token)
+		}
+		if got, want := b.PageURL(token), "https://legacy.example.com/bucket?encoding-type=url&marker=b+c"; got != want {
+			t.Errorf("PageURL(%q) = %q, want %q", token, got, want)
+		}
+		// Other buckets on the same endpoint use ListObjects from the start
+		other := NewS3Bucket("https://legacy.example.com/other", "other")
+		if got, want := other.PageURL(""), "https://legacy.example.com/other?encoding-type=url"; got != want {
+			t.Errorf("PageURL(\"\") = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("start key", func(t *testing.T) {
+		b := NewS3Bucket("https://startkey.s3.amazonaws.com", "startkey")
+		if got, want := b.PageURL("photos/2020/a.jpg"), "https://startkey.s3.amazonaws.com?list-type=2&encoding-type=url&start-after=photos%2F2020%2Fa.jpg"; got != want {
+			t.Errorf("PageURL(\"photos/2020/a.jpg\") = %q, want %q", got, want)
+		}
+	})
+}
+
+func TestS3Query(t *testing.T) {
+	b, err := ParseURL("https://query.example.com/bucket?list-type=2&marker=m&prefix=p&continuation-token=c&max-keys=5&X-Amz-Credential=cred&token=abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s3, ok := b.(S3Bucket)
+	if !ok {
+		t.Fatalf("ParseURL returned %T, want S3Bucket", b)
+	}
+
+	// Listing parameters are dropped, others are sent with every request
+	tests := map[string]string{
+		"":          "https://query.example.com/bucket?list-type=2&encoding-type=url&X-Amz-Credential=cred&token=abc",
+		"start key": "https://query.example.com/bucket?list-type=2&encoding-type=url&start-after=start+key&X-Amz-Credential=cred&token=abc",
+	}
+	for key, want := range tests {
+		if got := s3.PageURL(key); got != want {
+			t.Errorf("PageURL(%q) = %q, want %q", key, got, want)
+		}
+	}
+	if got, want := s3.ResourceURL("a b"), "https://query.example.com/bucket/a%20b?X-Amz-Credential=cred&token=abc"; got != want {
+		t.Errorf("ResourceURL(\"a b\") = %q, want %q", got, want)
+	}
+
+	stripped := s3.WithoutCredentials()
+	if got, want := stripped.PageURL(""), "https://query.example.com/bucket?list-type=2&encoding-type=url"; got != want {
+		t.Errorf("PageURL(\"\") without credentials = %q, want %q", got, want)
+	}
+	if got, want := stripped.ResourceURL("a b"), "https://query.example.com/bucket/a%20b"; got != want {
+		t.Errorf("ResourceURL(\"a b\") without credentials = %q, want %q", got, want)
+	}
+}
+
+func TestGoogleStorageGenerations(t *testing.T) {
+	b := NewGoogleStorageBucket("example").WithVersions()
+
+	page := `<ListBucketResult><EncodingType>url</EncodingType><IsTruncated>true</IsTruncated>
+		<NextMarker>a%2Bb</NextMarker><NextGenerationMarker>1700000000000002</NextGenerationMarker>
+		<Contents><Key>a%2Bb</Key><Generation>1700000000000001</Generation></Contents>
+		<Contents><Key>a%2Bb</Key><Generation>1700000000000002</Generation></Contents>
+	</ListBucketResult>`
+	objects, token, err := b.ParsePage([]byte(page))
+	if err != nil {
+		t.Fatalf("ParsePage failed: %s", err)
+	}
+	// More generations of the last key may follow on the next page
+	if len(objects) != 0 {
+		t.Fatalf("ParsePage returned %d objects, want 0", len(objects))
+	}
+	if got, want := b.PageURL(token), "https://example.storage.googleapis.com/?versions=true&encoding-type=url&generation-marker=1700000000000002&marker=a%2Bb"; got != want {
+		t.Errorf("PageURL(%q) = %q, want %q", token, got, want)
+	}
+
+	page = `<ListBucketResult><EncodingType>url</EncodingType><IsTruncated>false</IsTruncated>
+		<Contents><Key>a%2Bb</Key><Generation>999999999999999</Generation></Contents>
+		<Contents><Key>c</Key><Generation>1700000000000003</Generation></Contents>
+	</ListBucketResult>`
+	objects, _, err = b.ParsePage([]byte(page))
+	if err != nil {
+		t.Fatalf("ParsePage failed: %s", err)
+	}
+	var latest []bool
+	for _, o := range objects {
+		latest = append(latest, o.IsLatest)
+	}
+	if want := []bool{false, true, false, true}; !reflect.DeepEqual(latest, want) {
+		t.Errorf("ParsePage returned IsLatest %v, want %v", latest, want)
+	}
+	if got, want := ObjectURL(b, objects[0]), "https://example.storage.googleapis.com/a%2Bb?generation=1700000000000001"; got != want {
+		t.Errorf("ObjectURL = %q, want %q", got, want)
+	}
+
+	jb := NewGoogleStorageJSONBucket("example").WithVersions()
+	if got, want := jb.PageURL("token"), "https://storage.googleapis.com/storage/v1/b/example/o?versions=true&pageToken=token"; got != want {
+		t.Errorf("PageURL(\"token\") = %q, want %q", got, want)
+	}
+	objects, _, err = jb.ParsePage([]byte(`{"items":[
+		{"name":"a","generation":"1","timeDeleted":"2024-01-01T00:00:00Z"},
+		{"name":"a","generation":"2"}]}`))
+	if err != nil {
+		t.Fatalf("ParsePage failed: %s", err)
+	}
+	if objects[0].IsLatest || !objects[1].IsLatest {
+		t.Errorf("ParsePage returned IsLatest %v, %v, want false, true", objects[0].IsLatest, objects[1].IsLatest)
+	}
+	if got, want := ObjectURL(jb, objects[0]), "https://storage.googleapis.com/download/storage/v1/b/example/o/a?alt=media&generation=1"; got != want {
+		t.Errorf("ObjectURL = %q, want %q", got, want)
+	}
+}
+
+func TestAzureInclude(t *testing.T) {
+	b, err := NewAzureStorageBucket("account", "container").WithInclude([]string{"snapshots", "versions", "metadata", "tags"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.WithInclude([]string{"everything"}); err == nil {
+		t.Error("WithInclude accepted an unknown option")
+	}
+	if got, want := b.PageURL("m"), "https://account.blob.core.windows.net/container?restype=container&comp=list&include=snapshots,versions,metadata,tags&marker=m"; got != want {
+		t.Errorf("PageURL(\"m\") = %q, want %q", got, want)
+	}
+
+	page := `<EnumerationResults><Blobs>
+		<Blob><Name>a b</Name><Snapshot>2024-01-01T00:00:00.0000000Z</Snapshot><Properties /></Blob>
+		<Blob><Name>a b</Name><VersionId>2024-01-02T00:00:00.0000000Z</VersionId><IsCurrentVersion>true</IsCurrentVersion>
+			<Metadata><owner>alice</owner></Metadata>
+			<Tags><TagSet><Tag><Key>env</Key><Value>prod</Value></Tag></TagSet></Tags><Properties /></Blob>
+		<Blob><Name>gone</Name><Deleted>true</Deleted><Properties /></Blob>
+	</Blobs><NextMarker /></EnumerationResults>`
+	objects, _, err := b.ParsePage([]byte(page))
+	if err != nil {
+		t.Fatalf("ParsePage failed: %s", err)
+	}
+	want := []Object{
+		{Key: "a b", Snapshot: "2024-01-01T00:00:00.0000000Z"},
+		{Key: "a b", VersionID: "2024-01-02T00:00:00.0000000Z", IsLatest: true,
+			Metadata: map[string]string{"owner": "alice"}, Tags: map[string]string{"env": "prod"}},
+		{Key: "gone", Deleted: true},
+	}
+	if !reflect.DeepEqual(objects, want) {
+		t.Errorf("ParsePage returned %+v, want %+v", objects, want)
+	}
+	if got, want := ObjectURL(b, objects[0]), "https://account.blob.core.windows.net/container/a%20b?snapshot=2024-01-01T00%3A00%3A00.0000000Z"; got != want {
+		t.Errorf("ObjectURL = %q, want %q", got, want)
+	}
+	if got, want := ObjectURL(b, objects[1]), "https://account.blob.core.windows.net/container/a%20b?versionid=2024-01-02T00%3A00%3A00.0000000Z"; got != want {
+		t.Errorf("ObjectURL = %q, want %q", got, want)
+	}
+}
+
+func TestFirestoreMetadata(t *testing.T) {
+	b := NewFirestoreBucket("example.appspot.com")
+	if got, want := b.MetadataURL("images/a b.png"), "https://firebasestorage.googleapis.com/v0/b/example.appspot.com/o/images%2Fa%20b.png"; got != want {
+		t.Errorf("MetadataURL = %q, want %q", got, want)
+	}
+
+	o, err := b.ParseMetadata([]byte(`{"name":"images/a b.png","size":"42","contentType":"image/png",
+		"md5Hash":"1B2M2Y8AsgTpgAmY7PhCfg==","timeCreated":"2024-01-01T00:00:00.000Z",
+		"downloadTokens":"3f7a-token,9c1d-token"}`), Object{Key: "images/a b.png"})
+	if err != nil {
+		t.Fatalf("ParseMetadata failed: %s", err)
+	}
+	want := Object{
+		Key:           "images/a b.png",
+		Size:          42,
+		ContentType:   "image/png",
+		MD5Hash:       "1B2M2Y8AsgTpgAmY7PhCfg==",
+		TimeCreated:   "2024-01-01T00:00:00.000Z",
+		DownloadToken: "3f7a-token",
+	}
+	if !reflect.DeepEqual(o, want) {
+		t.Errorf("ParseMetadata returned %+v, want %+v", o, want)
+	}
+	if got, want := ObjectURL(b, o), "https://firebasestorage.googleapis.com/v0/b/example.appspot.com/o/images%2Fa%20b.png?alt=media&token=3f7a-token"; got != want {
+		t.Errorf("ObjectURL = %q, want %q", got, want)
+	}
+	if got, want := ObjectURL(b.WithoutCredentials(), o), "https://firebasestorage.googleapis.com/v0/b/example.appspot.com/o/images%2Fa%20b.png?alt=media"; got != want {
+		t.Errorf("ObjectURL without credentials = %q, want %q", got, want)
+	}
+}
+
+func TestFirestorePrefix(t *testing.T) {
+	b := NewFirestoreBucket("example.appspot.com").WithPrefix("images/2024 q1/")
+	if got, want := b.PageURL("token"), "https://firebasestorage.googleapis.com/v0/b/example.appspot.com/o?prefix=images%2F2024+q1%2F&delimiter=%2F&pageToken=token"; got != want {
+		t.Errorf("PageURL(\"token\") = %q, want %q", got, want)
+	}
+	objects, _, err := b.ParsePage([]byte(`{"prefixes":["images/2024 q1/raw/"],"items":[{"name":"images/2024 q1/a.png"}]}`))
+	if err != nil {
+		t.Fatalf("ParsePage failed: %s", err)
+	}
+	want := []Object{
+		{Key: "images/2024 q1/raw/", Directory: true},
+		{Key: "images/2024 q1/a.png"},
+	}
+	if !reflect.DeepEqual(objects, want) {
+		t.Errorf("ParsePage returned %+v, want %+v", objects, want)
+	}
+}
+
+func TestFirestoreFlat(t *testing.T) {
+	b := NewFirestoreBucket("example.appspot.com")
+	objects, _, err := b.ParsePage([]byte(`{"prefixes":["images/","videos/"],"items":[{"name":"a.txt"}]}`))
+	if err != nil {
+		t.Fatalf("ParsePage failed: %s", err)
+	}
+	if want := []Object{{Key: "a.txt"}}; !reflect.DeepEqual(objects, want) {
+		t.Errorf("ParsePage returned %+v, want %+v", objects, want)
+	}
+}
+
+func TestS3Redirect(t *testing.T) {
+	tests := []struct {
+		name       string
+		baseURL    string
+		statusCode int
+		header     http.Header
+		body       string
+		wantURL    string
+		wantRegion string
+	}{
+		{
+			name:       "virtual-hosted",
+			baseURL:    "https://example.s3.amazonaws.com",
+			statusCode: http.StatusMovedPermanently,
+			header:     http.Header{"X-Amz-Bucket-Region": {"eu-west-1"}},
+			body:       "<Error><Code>PermanentRedirect</Code><Endpoint>example.s3.eu-west-1.amazonaws.com</Endpoint></Error>",
+			wantURL:    "https://example.s3.eu-west-1.amazonaws.com",
+			wantRegion: "eu-west-1",
+		},
+		{
+			name:       "path-style",
+			baseURL:    "https://s3.us-east-2.amazonaws.com/example",
+			statusCode: http.StatusMovedPermanently,
+			header:     http.Header{"X-Amz-Bucket-Region": {"ap-southeast-2"}},
+			wantURL:    "https://s3.ap-southeast-2.amazonaws.com/example",
+			wantRegion: "ap-southeast-2",
+		},
+		{
+			name:       "legacy dash endpoint",
+			baseURL:    "https://example.s3-us-west-2.amazonaws.com",
+			statusCode: http.StatusBadRequest,
+			body:       "<Error><Code>AuthorizationHeaderMalformed</Code><Region>eu-central-1</Region></Error>",
+			wantURL:    "https://example.s3.eu-central-1.amazonaws.com",
+			wantRegion: "eu-central-1",
+		},
+		{
+			name:       "other provider",
+			baseURL:    "https://storage.example.com/example",
+			statusCode: http.StatusMovedPermanently,
+			body:       "<Error><Code>PermanentRedirect</Code><Endpoint>eu.storage.example.com</Endpoint></Error>",
+			wantURL:    "https://eu.storage.example.com/example",
+		},
+		{
+			name:       "access denied",
+			baseURL:    "https://example.s3.amazonaws.com",
+			statusCode: http.StatusForbidden,
+			header:     http.Header{"X-Amz-Bucket-Region": {"us-east-1"}},
+			body:       "<Error><Code>AccessDenied</Code></Error>",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b, ok := NewS3Bucket(test.baseURL, "example").Redirect(test.statusCode, test.header, []byte(test.body))
+			if test.wantURL == "" {
+				if ok {
+					t.Fatalf("Redirect returned %s, want no redirect", b.URL())
+				}
+				return
+			}
+			if !ok {
+				t.Fatal("Redirect returned no redirect")
+			}
+			if b.URL() != test.wantURL {
+				t.Errorf("Redirect returned URL %q, want %q", b.URL(), test.wantURL)
+			}
+			if region := b.(S3Bucket).Region(); region != test.wantRegion {
+				t.Errorf("Redirect returned region %q, want %q", region, test.wantRegion)
+			}
+		})
+	}
+}
+
+func TestS3Region(t *testing.T) {
+	tests := map[string]string{
+		"https://example.s3.amazonaws.com":                      "",
+		"https://s3.amazonaws.com/example":                      "",
+		"https://example.s3-accelerate.amazonaws.com":           "",
+		"https://example.s3-accelerate.dualstack.amazonaws.com": "",
+		"https://example.s3.eu-west-1.amazonaws.com":            "eu-west-1",
+		"https://s3.dualstack.ap-south-1.amazonaws.com/example": "ap-south-1",
+		"https://example.s3-us-west-2.amazonaws.com":            "us-west-2",
+		"https://example.s3-external-1.amazonaws.com":           "us-east-1",
+		"https://example.s3-website-eu-central-1.amazonaws.com": "eu-central-1",
+		"https://minio.example.com/example":                     "",
+	}
+	for baseURL, want := range tests {
+		if got := NewS3Bucket(baseURL, "example").Region(); got != want {
+			t.Errorf("Region() of %s = %q, want %q", baseURL, got, want)
+		}
+	}
+}
+
+func TestS3Service(t *testing.T) {
+	s, err := ParseServiceURL("http://minio.example.com:9000/?token=abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, ok := s.(S3Service)
+	if !ok {
+		t.Fatalf("ParseServiceURL returned %T, want S3Service", s)
+	}
+	if got, want := service.ListURL("next"), "http://minio.example.com:9000/?continuation-token=next&token=abc"; got != want {
+		t.Errorf("ListURL(\"next\") = %q, want %q", got, want)
+	}
+
+	buckets, token, err := service.ParseList([]byte(`<ListAllMyBucketsResult><Owner><ID>minio</ID></Owner>
+		<Buckets><Bucket><Name>backups</Name></Bucket><Bucket><Name>public</Name></Bucket></Buckets>
+	</ListAllMyBucketsResult>`))
+	if err != nil {
+		t.Fatalf("ParseList failed: %s", err)
+	}
+	if token != "" || len(buckets) != 2 {
+		t.Fatalf("ParseList returned %d buckets and token %q, want 2 buckets and no token", len(buckets), token)
+	}
+	if got, want := buckets[0].Name(), "minio.example.com:9000-backups"; got != want {
+		t.Errorf("Name() = %q, want %q", got, want)
+	}
+	if got, want := buckets[1].ResourceURL("a b"), "http://minio.example.com:9000/public/a%20b?token=abc"; got != want {
+		t.Errorf("ResourceURL(\"a b\") = %q, want %q", got, want)
+	}
+
+	// A virtual-hosted bucket at the same URL returns a bucket listing instead
+	if _, _, err := service.ParseList([]byte(`<ListBucketResult><Name>example</Name></ListBucketResult>`)); err == nil {
+		t.Error("ParseList accepted a bucket listing")
+	}
+
+	for _, input := range []string{"https://s3.amazonaws.com", "https://s3.eu-west-1.amazonaws.com/", "https://nyc3.digitaloceanspaces.com"} {
+		s, err := ParseServiceURL(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := s.(S3Service); !ok {
+			t.Errorf("ParseServiceURL(%q) returned %T, want S3Service", input, s)
+		}
+	}
+
+	// Hosts that already name a bucket aren't listed as services
+	for _, input := range []string{
+		"https://minio.example.com/bucket",
+		"https://example.blob.core.windows.net/container",
+		"https://example.s3.amazonaws.com",
+		"https://my.bucket.s3-us-west-2.amazonaws.com/",
+		"https://example.s3.wasabisys.com",
+		"https://example.nyc3.digitaloceanspaces.com",
+	} {
+		s, err := ParseServiceURL(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := s.(S3Service); ok {
+			t.Errorf("ParseServiceURL(%q) returned an S3Service", input)
+		}
+	}
+}
+
+func TestParseURL(t *testing.T) {
+	tests := []struct {
+		input    string
+		wantType string
+		wantName string
+		wantURL  string
+	}{
+		{"https://storage.googleapis.com/storage/v1/b/example-bucket/o", "GoogleStorageJSONBucket", "example-bucket", "https://storage.googleapis.com/storage/v1/b/example-bucket/o"},
+		{"https://www.googleapis.com/storage/v1/b/example.com/o?prefix=a", "GoogleStorageJSONBucket", "example.com", "https://storage.googleapis.com/storage/v1/b/example.com/o"},
+		{"https://storage.googleapis.com/example-bucket", "GoogleStorageBucket", "example-bucket", "https://example-bucket.storage.googleapis.com/"},
+		{"https://example-bucket.storage.googleapis.com/", "GoogleStorageBucket", "example-bucket", "https://example-bucket.storage.googleapis.com/"},
+		{"https://swift.example.com/v1/AUTH_abc123/public/", "SwiftBucket", "swift.example.com-AUTH_abc123-public", "https://swift.example.com/v1/AUTH_abc123/public"},
+		{"https://ceph.example.com/swift/v1/AUTH_tenant/container/file.txt", "SwiftBucket", "ceph.example.com-AUTH_tenant-container", "https://ceph.example.com/swift/v1/AUTH_tenant/container"},
+		{"https://example.oss-cn-hangzhou.aliyuncs.com/", "OSSBucket", "oss-cn-hangzhou.aliyuncs.com-example", "https://example.oss-cn-hangzhou.aliyuncs.com/"},
+		{"https://oss-eu-central-1.aliyuncs.com/example/dir/file.txt", "OSSBucket", "oss-eu-central-1.aliyuncs.com-example", "https://example.oss-eu-central-1.aliyuncs.com/"},
+		{"https://objectstorage.us-ashburn-1.oraclecloud.com/n/tenancy/b/public/o", "OCIBucket", "tenancy-public", "https://objectstorage.us-ashburn-1.oraclecloud.com/n/tenancy/b/public/o"},
+		{"https://objectstorage.eu-frankfurt-1.oraclecloud.com/p/AbC-123_x/n/tenancy/b/shared/o/", "OCIBucket", "tenancy-shared", "https://objectstorage.eu-frankfurt-1.oraclecloud.com/p/AbC-123_x/n/tenancy/b/shared/o"},
+		{"https://tenancy.objectstorage.us-phoenix-1.oci.customer-oci.com/n/tenancy/b/files", "OCIBucket", "tenancy-files", "https://tenancy.objectstorage.us-phoenix-1.oci.customer-oci.com/n/tenancy/b/files/o"},
+		{"https://minio.example.com/bucket", "S3Bucket", "minio.example.com-bucket", "https://minio.example.com/bucket"},
+	}
+	for _, test := range tests {
+		b, err := ParseURL(test.input)
+		if err != nil {
+			t.Errorf("ParseURL(%q) failed: %s", test.input, err)
+			continue
+		}
+		if got := fmt.Sprintf("%T", b); got != "bucket."+test.wantType {
+			t.Errorf("ParseURL(%q) returned %s, want %s", test.input, got, test.wantType)
+		}
+		if got := b.Name(); got != test.wantName {
+			t.Errorf("ParseURL(%q).Name() = %q, want %q", test.input, got, test.wantName)
+		}
+		if got := b.URL(); got != test.wantURL {
+			t.Errorf("ParseURL(%q).URL() = %q, want %q", test.input, got, test.wantURL)
+		}
+	}
+}
+
+func TestOSSRegion(t *testing.T) {
+	tests := map[string]string{
+		"https://example.oss-cn-hangzhou.aliyuncs.com/":            "cn-hangzhou",
+		"https://oss-eu-central-1.aliyuncs.com/example":            "eu-central-1",
+		"https://example.oss-ap-southeast-1-internal.aliyuncs.com": "ap-southeast-1",
+	}
+	for input, want := range tests {
+		b, err := ParseURL(input)
+		if err != nil {
+			t.Fatalf("ParseURL(%q) failed: %s", input, err)
+		}
+		oss, ok := b.(OSSBucket)
+		if !ok {
+			t.Fatalf("ParseURL(%q) returned %T, want OSSBucket", input, b)
+		}
+		if got := oss.Region(); got != want {
+			t.Errorf("ParseURL(%q).Region() = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestSAS(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		query    string
+		wantErr  bool
+		warnings []string
+	}{
+		{name: "no SAS", query: "foo=bar"},
+		{name: "missing sig", query: "sv=2021-08-06&sp=rl&se=2025-01-01T00:00:00Z", wantErr: true},
+		{name: "valid container SAS", query: "sv=2021-08-06&sr=c&sp=rl&se=2025-01-01T00:00:00Z&sig=abc&foo=bar"},
+		{name: "directory SAS", query: "sv=2021-08-06&sr=d&sdd=1&sp=rl&se=2025-01-01&sig=abc"},
+		{
+			name:     "expired",
+			query:    "sv=2021-08-06&sr=c&sp=rl&se=2024-01-01T00:00:00Z&sig=abc",
+			warnings: []string{"SAS token expired at 2024-01-01T00:00:00Z"},
+		},
+		{
+			name:     "unparseable expiry",
+			query:    "sv=2021-08-06&sr=c&sp=rl&se=tomorrow&sig=abc",
+			warnings: []string{"SAS token has an unreadable expiry time (se=tomorrow)"},
+		},
+		{
+			name:     "no list permission",
+			query:    "sv=2021-08-06&sr=c&sp=r&se=2025-01-01T00:00Z&sig=abc",
+			warnings: []string{"SAS token lacks list permission (sp=r)"},
+		},
+		{
+			name:     "blob SAS",
+			query:    "sv=2021-08-06&sr=b&sp=rl&se=2025-01-01T00:00:00.0000000Z&sig=abc",
+			warnings: []string{"SAS token is scoped to a single resource rather than a container (sr=b)"},
+		},
+		{
+			name:     "snapshot SAS",
+			query:    "sv=2021-08-06&sr=bs&sp=rl&se=2025-01-01&sig=abc",
+			warnings: []string{"SAS token is scoped to a single resource rather than a container (sr=bs)"},
+		},
+		{name: "account SAS", query: "sv=2021-08-06&ss=bf&srt=sco&sp=rl&se=2025-01-01&sig=abc"},
+		{
+			name:     "account SAS without blob service",
+			query:    "sv=2021-08-06&ss=qt&srt=sco&sp=rl&se=2025-01-01&sig=abc",
+			warnings: []string{"SAS token doesn't grant access to the blob service (ss=qt)"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sas, err := ParseSAS(test.query)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ParseSAS returned error %v, want error %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if strings.Contains(sas.Encode(), "foo") {
+				t.Errorf("ParseSAS kept a parameter that isn't part of the signature: %s", sas.Encode())
+			}
+			if got := sas.Warnings(now); !reflect.DeepEqual(got, test.warnings) {
+				t.Errorf("Warnings() = %q, want %q", got, test.warnings)
+			}
+		})
+	}
+}
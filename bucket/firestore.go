@@ -3,18 +3,29 @@ package bucket
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"net/url"
+	"strings"
 )
 
 // Type FirestoreBucket represents a Firebase storage bucket hosted on Google Cloud Platform.
 type FirestoreBucket struct {
 	// The bucket name.
 	name string
+
+	// The prefix to list, when listing a single level of folders.
+	prefix string
+
+	// Set to list a single level of folders using a delimiter.
+	delimited bool
+
+	// Set to leave download tokens out of object URLs.
+	withoutTokens bool
 }
 
 // Type FirestoreBucketPage is a helper type for storing JSON data.
 type FirestoreBucketPage struct {
-	Prefixes []interface{} `json:"prefixes"`
+	Prefixes []string `json:"prefixes"`
 	Items    []struct {
 		Name   string `json:"name"`
 		Bucket string `json:"bucket"`
@@ -22,12 +33,49 @@ type FirestoreBucketPage struct {
 	Nextpagetoken string `json:"nextPageToken"`
 }
 
+// Type FirestoreObjectMetadata is a helper type for storing JSON data
+// returned for a single object.
+type FirestoreObjectMetadata struct {
+	Name           string `json:"name"`
+	Bucket         string `json:"bucket"`
+	Generation     string `json:"generation"`
+	ContentType    string `json:"contentType"`
+	TimeCreated    string `json:"timeCreated"`
+	Updated        string `json:"updated"`
+	Size           string `json:"size"`
+	MD5Hash        string `json:"md5Hash"`
+	CRC32C         string `json:"crc32c"`
+	ETag           string `json:"etag"`
+	DownloadTokens string `json:"downloadTokens"`
+}
+
 func NewFirestoreBucket(name string) FirestoreBucket {
 	return FirestoreBucket{
 		name: name,
 	}
 }
 
+// Returns a copy of the bucket that lists the objects and folders directly
+// within the prefix. Folders are returned as directories.
+func (bucket FirestoreBucket) WithPrefix(prefix string) Bucket {
+	bucket.prefix = prefix
+	bucket.delimited = true
+	return bucket
+}
+
+// Returns a copy of the bucket that leaves download tokens out of object
+// URLs, since each token grants access to its object.
+func (bucket FirestoreBucket) WithoutCredentials() Bucket {
+	bucket.withoutTokens = true
+	return bucket
+}
+
+// Returns true when listing a single level, since folders and objects are
+// each sorted but returned separately.
+func (bucket FirestoreBucket) Unordered() bool {
+	return bucket.delimited
+}
+
 // Returns the name of the bucket.
 func (bucket FirestoreBucket) Name() string {
 	return bucket.name
@@ -40,33 +88,101 @@ func (bucket FirestoreBucket) URL() string {
 
 // Returns the URL pointing to the position in the bucket indicated by the pagination key.
 func (bucket FirestoreBucket) PageURL(paginationKey string) string {
+	target := bucket.URL()
+	if bucket.delimited {
+		target = fmt.Sprintf("%s?prefix=%s&delimiter=%%2F", target, url.QueryEscape(bucket.prefix))
+	}
 	if paginationKey == "" {
-		return bucket.URL()
+		return target
 	}
-	return fmt.Sprintf("%s?pageToken=%s", bucket.URL(), url.QueryEscape(paginationKey))
+	return appendQuery(target, fmt.Sprintf("pageToken=%s", url.QueryEscape(paginationKey)))
 }
 
 // Returns the URL used to fetch the resource with the specified key.
-// TODO: Support extracting download key from metadata.
+// Firebase requires the whole object name as a single path segment.
 func (bucket FirestoreBucket) ResourceURL(key string) string {
-	return fmt.Sprintf("%s/%s?alt=media", bucket.URL(), url.QueryEscape(key))
+	return fmt.Sprintf("%s/%s?alt=media", bucket.URL(), escapeSegment(key))
+}
+
+// Returns the URL used to fetch the object, including its download token
+// if known. Objects protected by security rules can still be downloaded
+// with a token.
+func (bucket FirestoreBucket) ObjectURL(o Object) string {
+	if o.DownloadToken == "" || bucket.withoutTokens {
+		return bucket.ResourceURL(o.Key)
+	}
+	return appendQuery(bucket.ResourceURL(o.Key), url.Values{"token": {o.DownloadToken}}.Encode())
+}
+
+// Returns the requests used to write a canary object with the Firebase
+// upload API. Uploads can't be made conditional, so the existence check
+// must confirm the key is free.
+func (bucket FirestoreBucket) WriteProbe(key string) WriteProbe {
+	return WriteProbe{
+		ExistsURL:     bucket.MetadataURL(key),
+		UploadMethod:  http.MethodPost,
+		UploadURL:     fmt.Sprintf("%s?uploadType=media&name=%s", bucket.URL(), url.QueryEscape(key)),
+		UploadHeaders: map[string]string{"Content-Type": "text/plain"},
+		ReadURL:       bucket.ResourceURL(key),
+		DeleteURL:     bucket.MetadataURL(key),
+	}
+}
+
+// Returns the URL used to fetch the metadata of the object with the specified key.
+func (bucket FirestoreBucket) MetadataURL(key string) string {
+	return fmt.Sprintf("%s/%s", bucket.URL(), escapeSegment(key))
 }
 
-// Parses a response to a page request and returns a slice of the keys
-// and the next pagination key if applicable.
-func (bucket FirestoreBucket) ParsePage(data []byte) ([]string, string, error) {
-	var keys []string
+// Parses an object's metadata and returns the object with it merged in.
+// Only the first of the object's download tokens is kept.
+func (bucket FirestoreBucket) ParseMetadata(data []byte, o Object) (Object, error) {
+	var metadata FirestoreObjectMetadata
+	err := json.Unmarshal(data, &metadata)
+	if err != nil {
+		return o, err
+	}
+	o.Size = parseSize(metadata.Size)
+	o.ContentType = metadata.ContentType
+	o.MD5Hash = metadata.MD5Hash
+	o.CRC32C = metadata.CRC32C
+	o.ETag = metadata.ETag
+	o.Generation = metadata.Generation
+	o.TimeCreated = metadata.TimeCreated
+	o.LastModified = metadata.Updated
+	if metadata.DownloadTokens != "" {
+		o.DownloadToken = strings.Split(metadata.DownloadTokens, ",")[0]
+	}
+	return o, nil
+}
+
+// Parses a response to a page request and returns a slice of the objects
+// and the next pagination key if applicable. Folders returned when listing
+// a single level are included as directories.
+func (bucket FirestoreBucket) ParsePage(data []byte) ([]Object, string, error) {
+	var objects []Object
 	var page FirestoreBucketPage
 	var token string
 	err := json.Unmarshal(data, &page)
 	if err != nil {
 		return nil, "", err
 	}
+	// Prefixes sort apart from the items, so they are only listed when
+	// the listing is unordered
+	if bucket.delimited {
+		for _, prefix := range page.Prefixes {
+			objects = append(objects, Object{
+				Key:       prefix,
+				Directory: true,
+			})
+		}
+	}
 	for _, k := range page.Items {
-		keys = append(keys, k.Name)
+		objects = append(objects, Object{
+			Key: k.Name,
+		})
 	}
 	if page.Nextpagetoken != "" {
 		token = page.Nextpagetoken
 	}
-	return keys, token, nil
+	return objects, token, nil
 }
@@ -5,25 +5,44 @@ import (
 	"fmt"
 	"net/url"
 	"strings"
+	"sync"
 )
 
 // Type GoogleStorageBucket represents a Google Cloud Storage bucket.
 type GoogleStorageBucket struct {
 	// The name of the bucket.
 	name string
+
+	// Set to list every generation of each object.
+	versions bool
+
+	// Listing state shared between copies of the bucket.
+	listing *googleListing
+}
+
+// Type googleListing tracks the markers most recently returned by
+// ParsePage, so PageURL can tell them apart from a key to start after,
+// along with the generations of the last key on the previous page.
+type googleListing struct {
+	sync.Mutex
+	continuation string
+	pending      []Object
 }
 
 // Type GoogleStorageBucketPage is a helper type for storing XML data.
 type GoogleStorageBucketPage struct {
-	XMLName     xml.Name `xml:"ListBucketResult"`
-	Text        string   `xml:",chardata"`
-	Xmlns       string   `xml:"xmlns,attr"`
-	Name        string   `xml:"Name"`
-	Prefix      string   `xml:"Prefix"`
-	Marker      string   `xml:"Marker"`
-	NextMarker  string   `xml:"NextMarker"`
-	IsTruncated bool     `xml:"IsTruncated"`
-	Contents    []struct {
+	XMLName              xml.Name `xml:"ListBucketResult"`
+	Text                 string   `xml:",chardata"`
+	Xmlns                string   `xml:"xmlns,attr"`
+	Name                 string   `xml:"Name"`
+	Prefix               string   `xml:"Prefix"`
+	Marker               string   `xml:"Marker"`
+	NextMarker           string   `xml:"NextMarker"`
+	GenerationMarker     string   `xml:"GenerationMarker"`
+	NextGenerationMarker string   `xml:"NextGenerationMarker"`
+	EncodingType         string   `xml:"EncodingType"`
+	IsTruncated          bool     `xml:"IsTruncated"`
+	Contents             []struct {
 		Text           string `xml:",chardata"`
 		Key            string `xml:"Key"`
 		Generation     string `xml:"Generation"`
@@ -40,6 +59,14 @@ func NewGoogleStorageBucket(name string) GoogleStorageBucket {
 	}
 }
 
+// Returns a copy of the bucket that lists every generation of each object,
+// including noncurrent generations kept by object versioning.
+func (bucket GoogleStorageBucket) WithVersions() Bucket {
+	bucket.versions = true
+	bucket.listing = &googleListing{}
+	return bucket
+}
+
 // Returns the name of the bucket, which is the URL for a generic S3 bucket.
 func (bucket GoogleStorageBucket) Name() string {
 	return bucket.name
@@ -52,26 +79,56 @@ func (bucket GoogleStorageBucket) URL() string {
 
 // Returns the URL pointing to the position in the bucket indicated by the pagination key.
 func (bucket GoogleStorageBucket) PageURL(paginationKey string) string {
+	if bucket.versions {
+		switch {
+		case paginationKey == "":
+			return fmt.Sprintf("%s?versions=true&encoding-type=url", bucket.URL())
+		case paginationKey == bucket.continuation():
+			return fmt.Sprintf("%s?versions=true&encoding-type=url&%s", bucket.URL(), paginationKey)
+		default:
+			return fmt.Sprintf("%s?versions=true&encoding-type=url&marker=%s", bucket.URL(), url.QueryEscape(paginationKey))
+		}
+	}
 	if paginationKey == "" {
-		return bucket.URL()
+		return fmt.Sprintf("%s?encoding-type=url", bucket.URL())
 	}
-	return fmt.Sprintf("%s?marker=%s", bucket.URL(), url.QueryEscape(paginationKey))
+	return fmt.Sprintf("%s?encoding-type=url&marker=%s", bucket.URL(), url.QueryEscape(paginationKey))
 }
 
 // Returns the URL used to fetch the resource with the specified key.
-// TODO: Support extracting download key from metadata.
 func (bucket GoogleStorageBucket) ResourceURL(key string) string {
 	burl := bucket.URL()
 	if !strings.HasSuffix(burl, "/") {
 		burl = fmt.Sprintf("%s/", burl)
 	}
-	return fmt.Sprintf("%s%s", burl, url.QueryEscape(key))
+	return fmt.Sprintf("%s%s", burl, escapePath(key))
 }
 
-// Parses a response to a page request and returns a slice of the keys
-// and the next pagination key if applicable.
-func (bucket GoogleStorageBucket) ParsePage(data []byte) ([]string, string, error) {
-	var keys []string
+// Returns the bucket configuration endpoints to probe for public access.
+func (bucket GoogleStorageBucket) AuditChecks() []AuditCheck {
+	return googleAuditChecks(bucket.name)
+}
+
+// Returns the URL used to fetch the object, pinned to its generation when
+// listing every generation.
+func (bucket GoogleStorageBucket) ObjectURL(o Object) string {
+	if !bucket.versions || o.Generation == "" {
+		return bucket.ResourceURL(o.Key)
+	}
+	return appendQuery(bucket.ResourceURL(o.Key), url.Values{"generation": {o.Generation}}.Encode())
+}
+
+// Parses a response to a page request and returns a slice of the objects
+// and the next pagination key if applicable. When listing every generation,
+// the next pagination key holds both the key and generation markers.
+//
+// The XML API doesn't report which generation is live, so the highest
+// generation of each key is marked as the latest. Generations of the last
+// key on a truncated page are held back until the next page shows whether
+// more follow. A key whose live generation was deleted still has its
+// newest noncurrent generation marked; the JSON API reports this exactly.
+func (bucket GoogleStorageBucket) ParsePage(data []byte) ([]Object, string, error) {
+	var objects []Object
 	var page GoogleStorageBucketPage
 	var token string
 	err := xml.Unmarshal(data, &page)
@@ -79,10 +136,128 @@ func (bucket GoogleStorageBucket) ParsePage(data []byte) ([]string, string, erro
 		return nil, "", err
 	}
 	for _, k := range page.Contents {
-		keys = append(keys, k.Key)
+		objects = append(objects, Object{
+			Key:          decodeKey(page.EncodingType, k.Key),
+			Size:         parseSize(k.Size),
+			LastModified: k.LastModified,
+			ETag:         k.ETag,
+			Generation:   k.Generation,
+		})
+	}
+	if bucket.versions {
+		objects = bucket.markLatest(objects, page.IsTruncated)
 	}
 	if page.IsTruncated {
-		token = keys[len(keys)-1]
+		switch {
+		case bucket.versions && page.NextMarker != "" && page.NextGenerationMarker != "":
+			token = url.Values{
+				"marker":            {decodeKey(page.EncodingType, page.NextMarker)},
+				"generation-marker": {page.NextGenerationMarker},
+			}.Encode()
+			bucket.setContinuation(token)
+		case page.NextMarker != "":
+			token = decodeKey(page.EncodingType, page.NextMarker)
+		case len(objects) > 0:
+			token = objects[len(objects)-1].Key
+		default:
+			return objects, "", ErrEmptyTruncatedPage
+		}
+	}
+	return objects, token, nil
+}
+
+// Resumes a stalled listing with the last key listed as the marker,
+// rather than the NextMarker returned by the provider. Listings of every
+// generation can't be resumed without skipping generations of that key.
+func (bucket GoogleStorageBucket) Recover(lastKey string) (string, bool) {
+	if lastKey == "" || bucket.versions {
+		return "", false
+	}
+	return lastKey, true
+}
+
+// Returns the markers most recently returned by ParsePage.
+func (bucket GoogleStorageBucket) continuation() string {
+	if bucket.listing == nil {
+		return ""
+	}
+	bucket.listing.Lock()
+	defer bucket.listing.Unlock()
+	return bucket.listing.continuation
+}
+
+// Prepends the generations held back from the previous page, holds back
+// the generations of the last key if the page is truncated, and marks the
+// highest generation of each remaining key as the latest.
+func (bucket GoogleStorageBucket) markLatest(objects []Object, truncated bool) []Object {
+	if bucket.listing == nil {
+		return objects
+	}
+	bucket.listing.Lock()
+	defer bucket.listing.Unlock()
+	objects = append(bucket.listing.pending, objects...)
+	bucket.listing.pending = nil
+	if truncated && len(objects) > 0 {
+		last := len(objects)
+		for last > 0 && objects[last-1].Key == objects[len(objects)-1].Key {
+			last--
+		}
+		bucket.listing.pending = append([]Object(nil), objects[last:]...)
+		objects = objects[:last]
+	}
+
+	latest := -1
+	for i := range objects {
+		if latest >= 0 && objects[latest].Key != objects[i].Key {
+			objects[latest].IsLatest = true
+			latest = -1
+		}
+		if latest < 0 || compareGenerations(objects[i].Generation, objects[latest].Generation) > 0 {
+			latest = i
+		}
+	}
+	if latest >= 0 {
+		objects[latest].IsLatest = true
+	}
+	return objects
+}
+
+// Compares two generation numbers, which are decimal integers.
+func compareGenerations(a, b string) int {
+	if len(a) != len(b) {
+		if len(a) < len(b) {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// Records the markers returned by ParsePage.
+func (bucket GoogleStorageBucket) setContinuation(token string) {
+	if bucket.listing == nil {
+		return
+	}
+	bucket.listing.Lock()
+	defer bucket.listing.Unlock()
+	bucket.listing.continuation = token
+}
+
+// Permissions tested anonymously against Google Cloud Storage buckets.
+var googleAuditPermissions = []string{
+	"storage.buckets.get", "storage.buckets.getIamPolicy", "storage.buckets.setIamPolicy",
+	"storage.buckets.update", "storage.objects.list", "storage.objects.get",
+	"storage.objects.create", "storage.objects.delete",
+}
+
+// Returns the IAM endpoints of a Google Cloud Storage bucket to probe for
+// public access. testPermissions reports which permissions are granted
+// to anonymous users.
+func googleAuditChecks(name string) []AuditCheck {
+	iamURL := fmt.Sprintf("https://storage.googleapis.com/storage/v1/b/%s/iam", url.PathEscape(name))
+	return []AuditCheck{
+		{Name: "testPermissions", URL: fmt.Sprintf("%s/testPermissions?%s", iamURL, url.Values{"permissions": googleAuditPermissions}.Encode())},
+		{Name: "iam", URL: iamURL},
+		{Name: "metadata", URL: fmt.Sprintf("https://storage.googleapis.com/storage/v1/b/%s", url.PathEscape(name))},
 	}
-	return keys, token, nil
 }
@@ -0,0 +1,129 @@
+package bucket
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// Type GoogleStorageJSONBucket represents a Google Cloud Storage bucket
+// accessed through the JSON API rather than the XML API.
+type GoogleStorageJSONBucket struct {
+	// The name of the bucket.
+	name string
+
+	// Set to list every generation of each object.
+	versions bool
+}
+
+// Type GoogleStorageJSONBucketPage is a helper type for storing JSON data.
+type GoogleStorageJSONBucketPage struct {
+	Kind          string   `json:"kind"`
+	Prefixes      []string `json:"prefixes"`
+	NextPageToken string   `json:"nextPageToken"`
+	Items         []struct {
+		Kind           string `json:"kind"`
+		ID             string `json:"id"`
+		SelfLink       string `json:"selfLink"`
+		MediaLink      string `json:"mediaLink"`
+		Name           string `json:"name"`
+		Bucket         string `json:"bucket"`
+		Generation     string `json:"generation"`
+		Metageneration string `json:"metageneration"`
+		ContentType    string `json:"contentType"`
+		StorageClass   string `json:"storageClass"`
+		Size           string `json:"size"`
+		MD5Hash        string `json:"md5Hash"`
+		CRC32C         string `json:"crc32c"`
+		ETag           string `json:"etag"`
+		TimeCreated    string `json:"timeCreated"`
+		TimeDeleted    string `json:"timeDeleted"`
+		Updated        string `json:"updated"`
+	} `json:"items"`
+}
+
+func NewGoogleStorageJSONBucket(name string) GoogleStorageJSONBucket {
+	return GoogleStorageJSONBucket{
+		name: name,
+	}
+}
+
+// Returns a copy of the bucket that lists every generation of each object,
+// including noncurrent generations kept by object versioning.
+func (bucket GoogleStorageJSONBucket) WithVersions() Bucket {
+	bucket.versions = true
+	return bucket
+}
+
+// Returns the name of the bucket.
+func (bucket GoogleStorageJSONBucket) Name() string {
+	return bucket.name
+}
+
+// Returns the URL of the bucket.
+func (bucket GoogleStorageJSONBucket) URL() string {
+	return fmt.Sprintf("https://storage.googleapis.com/storage/v1/b/%s/o", bucket.name)
+}
+
+// Returns the URL pointing to the position in the bucket indicated by the pagination key.
+func (bucket GoogleStorageJSONBucket) PageURL(paginationKey string) string {
+	target := bucket.URL()
+	if bucket.versions {
+		target = fmt.Sprintf("%s?versions=true", target)
+	}
+	if paginationKey == "" {
+		return target
+	}
+	return appendQuery(target, fmt.Sprintf("pageToken=%s", url.QueryEscape(paginationKey)))
+}
+
+// Returns the URL used to fetch the resource with the specified key.
+// The JSON API requires the whole object name as a single path segment.
+func (bucket GoogleStorageJSONBucket) ResourceURL(key string) string {
+	return fmt.Sprintf("https://storage.googleapis.com/download/storage/v1/b/%s/o/%s?alt=media", bucket.name, escapeSegment(key))
+}
+
+// Returns the bucket configuration endpoints to probe for public access.
+func (bucket GoogleStorageJSONBucket) AuditChecks() []AuditCheck {
+	return googleAuditChecks(bucket.name)
+}
+
+// Returns the URL used to fetch the object, pinned to its generation when
+// listing every generation.
+func (bucket GoogleStorageJSONBucket) ObjectURL(o Object) string {
+	if !bucket.versions || o.Generation == "" {
+		return bucket.ResourceURL(o.Key)
+	}
+	return appendQuery(bucket.ResourceURL(o.Key), url.Values{"generation": {o.Generation}}.Encode())
+}
+
+// Parses a response to a page request and returns a slice of the objects
+// and the next pagination key if applicable. When listing every generation,
+// noncurrent generations are those with a deletion time.
+func (bucket GoogleStorageJSONBucket) ParsePage(data []byte) ([]Object, string, error) {
+	var objects []Object
+	var page GoogleStorageJSONBucketPage
+	var token string
+	err := json.Unmarshal(data, &page)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, k := range page.Items {
+		objects = append(objects, Object{
+			Key:          k.Name,
+			Size:         parseSize(k.Size),
+			LastModified: k.Updated,
+			ETag:         k.ETag,
+			ContentType:  k.ContentType,
+			MD5Hash:      k.MD5Hash,
+			CRC32C:       k.CRC32C,
+			Generation:   k.Generation,
+			MediaLink:    k.MediaLink,
+			IsLatest:     bucket.versions && k.TimeDeleted == "",
+		})
+	}
+	if page.NextPageToken != "" {
+		token = page.NextPageToken
+	}
+	return objects, token, nil
+}
@@ -0,0 +1,117 @@
+package bucket
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Fields requested in Oracle listings. Only the name is returned by default.
+const ociListFields = "name,size,etag,md5,timeCreated,timeModified"
+
+// Type OCIBucket represents an Oracle Cloud Infrastructure Object Storage
+// bucket, optionally accessed through a pre-authenticated request (PAR).
+type OCIBucket struct {
+	// The host serving the bucket, e.g. objectstorage.us-ashburn-1.oraclecloud.com.
+	host string
+
+	// The Object Storage namespace of the tenancy.
+	namespace string
+
+	// The name of the bucket.
+	name string
+
+	// The pre-authenticated request token, if any.
+	par string
+}
+
+// Type OCIBucketPage is a helper type for storing JSON data.
+type OCIBucketPage struct {
+	Objects []struct {
+		Name         string `json:"name"`
+		Size         int64  `json:"size"`
+		ETag         string `json:"etag"`
+		MD5          string `json:"md5"`
+		TimeCreated  string `json:"timeCreated"`
+		TimeModified string `json:"timeModified"`
+	} `json:"objects"`
+	Prefixes      []string `json:"prefixes"`
+	NextStartWith string   `json:"nextStartWith"`
+}
+
+func NewOCIBucket(host string, namespace string, name string, par string) OCIBucket {
+	return OCIBucket{
+		host:      strings.ToLower(host),
+		namespace: namespace,
+		name:      name,
+		par:       par,
+	}
+}
+
+// Returns the name of the bucket.
+func (bucket OCIBucket) Name() string {
+	return fmt.Sprintf("%s-%s", bucket.namespace, bucket.name)
+}
+
+// Returns the region the bucket is hosted in, if it can be determined from the host.
+func (bucket OCIBucket) Region() string {
+	hostFragments := strings.Split(bucket.host, ".")
+	for i, fragment := range hostFragments {
+		if fragment == "objectstorage" && i+1 < len(hostFragments) {
+			return hostFragments[i+1]
+		}
+	}
+	return ""
+}
+
+// Returns the URL of the bucket.
+func (bucket OCIBucket) URL() string {
+	if bucket.par != "" {
+		return fmt.Sprintf("https://%s/p/%s/n/%s/b/%s/o", bucket.host, bucket.par, bucket.namespace, bucket.name)
+	}
+	return fmt.Sprintf("https://%s/n/%s/b/%s/o", bucket.host, bucket.namespace, bucket.name)
+}
+
+// Returns the URL pointing to the position in the bucket indicated by the pagination key.
+func (bucket OCIBucket) PageURL(paginationKey string) string {
+	burl := bucket.URL()
+	if bucket.par != "" {
+		// PAR listings are addressed with a trailing slash.
+		burl = fmt.Sprintf("%s/", burl)
+	}
+	if paginationKey == "" {
+		return fmt.Sprintf("%s?fields=%s", burl, ociListFields)
+	}
+	return fmt.Sprintf("%s?fields=%s&start=%s", burl, ociListFields, url.QueryEscape(paginationKey))
+}
+
+// Returns the URL used to fetch the resource with the specified key.
+func (bucket OCIBucket) ResourceURL(key string) string {
+	return fmt.Sprintf("%s/%s", bucket.URL(), escapePath(key))
+}
+
+// Parses a response to a page request and returns a slice of the objects
+// and the next pagination key if applicable.
+func (bucket OCIBucket) ParsePage(data []byte) ([]Object, string, error) {
+	var objects []Object
+	var page OCIBucketPage
+	var token string
+	err := json.Unmarshal(data, &page)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, k := range page.Objects {
+		objects = append(objects, Object{
+			Key:          k.Name,
+			Size:         k.Size,
+			LastModified: k.TimeModified,
+			ETag:         k.ETag,
+			MD5Hash:      k.MD5,
+		})
+	}
+	if page.NextStartWith != "" {
+		token = page.NextStartWith
+	}
+	return objects, token, nil
+}
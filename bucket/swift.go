@@ -0,0 +1,91 @@
+package bucket
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Type SwiftBucket represents a public OpenStack Swift container, such as
+// those served by Rackspace Cloud Files.
+type SwiftBucket struct {
+	// The URL of the container, including the account path.
+	baseURL string
+
+	// The generated name of the bucket.
+	name string
+}
+
+// Type SwiftBucketPage is a helper type for storing JSON data.
+type SwiftBucketPage []struct {
+	Name         string `json:"name"`
+	Subdir       string `json:"subdir"`
+	Bytes        int64  `json:"bytes"`
+	Hash         string `json:"hash"`
+	LastModified string `json:"last_modified"`
+	ContentType  string `json:"content_type"`
+}
+
+func NewSwiftBucket(baseURL string, name string) SwiftBucket {
+	return SwiftBucket{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		name:    name,
+	}
+}
+
+// Returns the name of the bucket.
+func (bucket SwiftBucket) Name() string {
+	return bucket.name
+}
+
+// Returns the URL of the bucket.
+func (bucket SwiftBucket) URL() string {
+	return bucket.baseURL
+}
+
+// Returns the URL pointing to the position in the bucket indicated by the pagination key.
+func (bucket SwiftBucket) PageURL(paginationKey string) string {
+	if paginationKey == "" {
+		return fmt.Sprintf("%s?format=json", bucket.URL())
+	}
+	return fmt.Sprintf("%s?format=json&marker=%s", bucket.URL(), url.QueryEscape(paginationKey))
+}
+
+// Returns the URL used to fetch the resource with the specified key.
+func (bucket SwiftBucket) ResourceURL(key string) string {
+	return fmt.Sprintf("%s/%s", bucket.URL(), escapePath(key))
+}
+
+// Parses a response to a page request and returns a slice of the objects
+// and the next pagination key if applicable. Swift doesn't report whether
+// a listing is truncated, so listing continues until an empty page is returned.
+func (bucket SwiftBucket) ParsePage(data []byte) ([]Object, string, error) {
+	var objects []Object
+	var page SwiftBucketPage
+	var token string
+	err := json.Unmarshal(data, &page)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, k := range page {
+		if k.Name == "" {
+			// Pseudo-directories only appear when a delimiter is set.
+			continue
+		}
+		objects = append(objects, Object{
+			Key:          k.Name,
+			Size:         k.Bytes,
+			LastModified: k.LastModified,
+			ETag:         k.Hash,
+			ContentType:  k.ContentType,
+		})
+	}
+	if len(page) > 0 {
+		token = page[len(page)-1].Name
+		if token == "" {
+			token = page[len(page)-1].Subdir
+		}
+	}
+	return objects, token, nil
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/shellhazard/bucketbuster/bucket"
+	"github.com/shellhazard/bucketbuster/internal/audit"
+	"github.com/spf13/cobra"
+)
+
+var (
+	writeCanary bool // Test whether buckets are publicly writable with a canary object.
+
+	auditCmd = &cobra.Command{
+		Use:   "audit",
+		Short: "Report which bucket configuration endpoints are publicly readable.",
+		Long: `Anonymously probes the configuration endpoints of each bucket, such as
+S3 ACLs and policies, GCS IAM permissions and Azure container ACLs, and
+writes one JSON report per bucket describing which are readable along
+with their decoded contents.
+
+With --write-canary, also tests whether each bucket is publicly writable by
+uploading a uniquely named canary object, reading it back and deleting it.
+Only use this on buckets you are authorised to assess. Existing objects are
+never overwritten.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			inputs := inputURLs()
+
+			// Write reports to the outfile if one was given
+			var w io.Writer = os.Stdout
+			if outfile != "" {
+				f, err := os.Create(outfile)
+				if err != nil {
+					log.Fatalf("Error creating outfile: %s", err)
+				}
+				defer f.Close()
+				w = f
+			}
+			encoder := json.NewEncoder(w)
+
+			// Probes are sent without credentials to see what is public
+			client := &http.Client{}
+			for _, in := range inputs {
+				buckets, err := ResolveBuckets(in)
+				if err != nil {
+					log.Printf("Error parsing URL: %s", err)
+					continue
+				}
+				for _, b := range buckets {
+					if cb, ok := b.(bucket.CredentialBucket); ok {
+						b = cb.WithoutCredentials()
+					}
+					_, auditable := b.(bucket.AuditableBucket)
+					wb, writable := b.(bucket.WriteProbeBucket)
+					if !auditable && !(writable && writeCanary) {
+						log.Printf("Auditing %s isn't supported.", b.Name())
+						continue
+					}
+					worklog.Printf("Auditing %s.", b.Name())
+					report := audit.Audit(client, b)
+					if writable && writeCanary {
+						worklog.Printf("Writing canary object to %s.", b.Name())
+						result := audit.ProbeWrite(client, wb, audit.CanaryKey())
+						if result.Writable && !result.Deleted {
+							log.Printf("Warning for %s: canary object %s was written but couldn't be deleted.", b.Name(), result.Key)
+						}
+						report.Write = &result
+					}
+					if err := encoder.Encode(report); err != nil {
+						log.Fatalf("Error writing report: %s", err)
+					}
+				}
+			}
+		},
+	}
+)
+
+func init() {
+	auditCmd.Flags().BoolVar(&writeCanary, "write-canary", false, "Tests whether each bucket is publicly writable by uploading, reading back and deleting a uniquely named canary object. Supports S3, Azure and Firebase Storage.")
+	rootCmd.AddCommand(auditCmd)
+}
@@ -2,10 +2,14 @@ package cmd
 
 import (
 	"bufio"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	neturl "net/url"
 	"os"
 	"os/signal"
+	"strings"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -13,6 +17,7 @@ import (
 	logger "log"
 
 	"github.com/shellhazard/bucketbuster/bucket"
+	"github.com/shellhazard/bucketbuster/internal/auth"
 	"github.com/shellhazard/bucketbuster/internal/paginator"
 	"github.com/spf13/cobra"
 )
@@ -34,6 +39,39 @@ var (
 	verbose     bool   // Enable extended output from buckets.
 	input       string // The list of bucket URLs to index.
 	concurrency int    // The maximum number of buckets to index simultaneously.
+	stripCreds  bool   // Remove credentials from URLs written to output files.
+	versions    bool   // List every version of each object where supported.
+
+	azureInclude []string // Datasets to include in Azure container listings.
+
+	recursive            bool // Walk folder prefixes instead of listing the bucket flat.
+	traversalConcurrency int  // The maximum number of folders to list simultaneously per bucket.
+
+	fetchMetadata       bool // Fetch each object's metadata, such as Firebase download tokens.
+	metadataConcurrency int  // The maximum number of metadata requests to make simultaneously per bucket.
+
+	// AWS credentials
+	awsAccessKey    string // The access key ID used to sign requests.
+	awsSecretKey    string // The secret access key used to sign requests.
+	awsSessionToken string // The session token for temporary credentials.
+	awsRegion       string // The region to sign requests for.
+	awsFromEnv      bool   // Read AWS credentials from the standard environment variables.
+
+	// Google credentials
+	bearerToken      string // OAuth access token attached to Google Cloud Storage and Firebase requests.
+	firebaseAPIKey   string // Web API key of the Firebase project to sign in to.
+	firebaseEmail    string // Email address of the Firebase account to sign in with.
+	firebasePassword string // Password of the Firebase account to sign in with.
+	firebaseAuthURL  string // The Identity Toolkit endpoint to sign in against.
+
+	// Azure credentials
+	azureAccount    string // The storage account the key belongs to.
+	azureAccountKey string // The storage account key used to sign requests.
+	azureFromEnv    bool   // Read Azure credentials from the standard environment variables.
+
+	// Input URLs read from --input or --url
+	loadedInputs []string
+	inputsRead   bool
 
 	// Loggers
 	log     = logger.New(os.Stderr, "[bucketbuster] ", 0)
@@ -44,6 +82,9 @@ var (
 		Short: "Tool for indexing public storage buckets.",
 		Long: `A standalone tool to analyse and index public Amazon S3 
 and Google Cloud Storage buckets. See github.com/shellhazard/bucketbuster`,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			ConfigureClient()
+		},
 		Run: func(cmd *cobra.Command, args []string) {
 			log.Printf("Starting Bucketbuster %s.\n", version)
 
@@ -77,15 +118,9 @@ and Google Cloud Storage buckets. See github.com/shellhazard/bucketbuster`,
 					worklog.SetOutput(ioutil.Discard)
 				}
 
-				// Attempt to load file
-				file, err := os.Open(input)
-				if err != nil {
-					log.Fatalf("Failed to open input file: %s", err)
-				}
-				scanner := bufio.NewScanner(file)
-				scanner.Split(bufio.ScanLines)
-
 				log.Printf("Loading input URLs from file %s.", input)
+				lines := inputURLs()
+
 				// Catch interrupt globally
 				c := make(chan os.Signal, 2)
 				signal.Notify(c, os.Interrupt, syscall.SIGTERM)
@@ -101,36 +136,41 @@ and Google Cloud Storage buckets. See github.com/shellhazard/bucketbuster`,
 				}()
 				defer close(c)
 
-				// Read URLs from the file line by line
+				// Index the URLs line by line
 				sem := make(chan bool, concurrency)
-				for scanner.Scan() {
-					b, err := bucket.ParseURL(scanner.Text())
+				for _, line := range lines {
+					buckets, err := ResolveBuckets(line)
 					if err != nil {
 						log.Printf("Error parsing URL: %s", err)
 						continue
 					}
-					sem <- true
-					go func() {
-						defer func() {
-							<-sem
-							atomic.AddInt64(completedBuckets, 1)
+					for _, b := range buckets {
+						b := b
+						sem <- true
+						go func() {
+							defer func() {
+								<-sem
+								atomic.AddInt64(completedBuckets, 1)
+							}()
+							atomic.AddInt64(startedBuckets, 1)
+							IndexBucket(b, totalKeys, startedBuckets, completedBuckets, false)
 						}()
-						atomic.AddInt64(startedBuckets, 1)
-						IndexBucket(b, totalKeys, startedBuckets, completedBuckets, false)
-					}()
+					}
 				}
 				for i := 0; i < cap(sem); i++ {
 					sem <- true
 				}
 				// Parse URL parameter
 			} else if url != "" {
-				b, err := bucket.ParseURL(url)
+				buckets, err := ResolveBuckets(url)
 				if err != nil {
 					log.Fatalf("Failed to parse input URL: %s", err)
 				}
-				atomic.AddInt64(startedBuckets, 1)
-				IndexBucket(b, totalKeys, startedBuckets, completedBuckets, true)
-				atomic.AddInt64(completedBuckets, 1)
+				for _, b := range buckets {
+					atomic.AddInt64(startedBuckets, 1)
+					IndexBucket(b, totalKeys, startedBuckets, completedBuckets, len(buckets) == 1)
+					atomic.AddInt64(completedBuckets, 1)
+				}
 			}
 
 			// Completed work
@@ -148,10 +188,30 @@ func init() {
 	rootCmd.PersistentFlags().StringVarP(&startkey, "startkey", "s", "", "Specify the key to start paginating from if required. Ignored if using --input flag.")
 	rootCmd.PersistentFlags().StringVarP(&input, "input", "i", "", "A list of bucket URLs to index.")
 	rootCmd.PersistentFlags().StringVarP(&outfile, "outfile", "o", "", "The file to output keys/URLs to. Default {number}-{bucket-url}.txt. Ignored if using --input flag.")
-	rootCmd.PersistentFlags().StringVarP(&format, "format", "f", "url", "Specify the output format. \"url\" is the default and outputs resource URLs, \"key\" outputs the list of keys. \"csv\" outputs as key,url for use with massivedl.")
+	rootCmd.PersistentFlags().StringVarP(&format, "format", "f", "url", "Specify the output format. \"url\" is the default and outputs resource URLs, \"key\" outputs the list of keys. \"csv\" outputs as key,url for use with massivedl. \"json\" outputs one object per line including any metadata returned by the provider.")
 	rootCmd.PersistentFlags().BoolVarP(&appendFile, "append", "a", false, "Appends to the target file instead of overwriting it.")
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Detailed logging output.")
+	rootCmd.PersistentFlags().BoolVar(&stripCreds, "strip-credentials", false, "Removes credentials such as SAS tokens, access tokens and Firebase download tokens from output files, for safe sharing.")
+	rootCmd.PersistentFlags().BoolVar(&versions, "versions", false, "Lists every version of each object, including deleted and overwritten ones, where the provider supports it. Resource URLs address the specific version.")
+	rootCmd.PersistentFlags().StringSliceVar(&azureInclude, "azure-include", nil, fmt.Sprintf("Comma separated datasets to include in Azure container listings: %s. Resource URLs address the specific snapshot or version.", strings.Join(bucket.AzureIncludeOptions, ", ")))
+	rootCmd.PersistentFlags().BoolVarP(&recursive, "recursive", "r", false, "Walks folders breadth-first instead of listing the bucket flat, for Firebase Storage buckets whose root listing only shows folders.")
+	rootCmd.PersistentFlags().IntVar(&traversalConcurrency, "traversal-concurrency", 5, "The maximum number of folders to list simultaneously per bucket when using --recursive.")
+	rootCmd.PersistentFlags().BoolVar(&fetchMetadata, "fetch-metadata", false, "Fetches the metadata of each object where the listing omits it. For Firebase Storage this adds download tokens to resource URLs, along with sizes, content types, hashes and creation times.")
+	rootCmd.PersistentFlags().IntVar(&metadataConcurrency, "metadata-concurrency", 1, "The maximum number of metadata requests to make simultaneously per bucket when using --fetch-metadata.")
 	rootCmd.PersistentFlags().IntVarP(&concurrency, "concurrency", "c", 10, "The maximum number of buckets to index simultaneously. Default 10.")
+	rootCmd.PersistentFlags().StringVar(&awsAccessKey, "aws-access-key", "", "AWS access key ID used to sign requests to AWS and to S3 compatible endpoints given as input. Other providers are sent unsigned requests.")
+	rootCmd.PersistentFlags().StringVar(&awsSecretKey, "aws-secret-key", "", "AWS secret access key used to sign S3 requests.")
+	rootCmd.PersistentFlags().StringVar(&awsSessionToken, "aws-session-token", "", "AWS session token for temporary credentials.")
+	rootCmd.PersistentFlags().BoolVar(&awsFromEnv, "aws-from-env", false, "Reads AWS credentials not given as flags from $AWS_ACCESS_KEY_ID, $AWS_SECRET_ACCESS_KEY and $AWS_SESSION_TOKEN. Off by default so ambient credentials aren't sent to third-party buckets.")
+	rootCmd.PersistentFlags().StringVar(&awsRegion, "aws-region", "", "AWS region to sign requests for. Inferred from the bucket host if not set.")
+	rootCmd.PersistentFlags().StringVar(&bearerToken, "bearer-token", "", "OAuth access token attached to Google Cloud Storage and Firebase Storage requests.")
+	rootCmd.PersistentFlags().StringVar(&firebaseAPIKey, "firebase-api-key", "", "Web API key of a Firebase project. Signs in anonymously, or with --firebase-email and --firebase-password, and attaches the ID token to Firebase Storage requests.")
+	rootCmd.PersistentFlags().StringVar(&firebaseEmail, "firebase-email", "", "Email address of the Firebase account to sign in with.")
+	rootCmd.PersistentFlags().StringVar(&firebasePassword, "firebase-password", "", "Password of the Firebase account to sign in with.")
+	rootCmd.PersistentFlags().StringVar(&firebaseAuthURL, "firebase-auth-url", auth.DefaultIdentityToolkitURL, "The Identity Toolkit endpoint to sign in against.")
+	rootCmd.PersistentFlags().StringVar(&azureAccount, "azure-account", "", "Azure storage account the key belongs to. Defaults to the account in --url.")
+	rootCmd.PersistentFlags().StringVar(&azureAccountKey, "azure-account-key", "", "Azure storage account key used to sign requests with Shared Key authorization.")
+	rootCmd.PersistentFlags().BoolVar(&azureFromEnv, "azure-from-env", false, "Reads Azure credentials not given as flags from $AZURE_STORAGE_ACCOUNT and $AZURE_STORAGE_KEY. Off by default so ambient credentials aren't sent to third-party buckets.")
 
 	// rootCmd.MarkPersistentFlagRequired("url")
 }
@@ -163,6 +223,272 @@ func Execute() {
 	}
 }
 
+// Configures the HTTP client shared by the paginator according to the
+// authentication flags.
+func ConfigureClient() {
+	// Ambient credentials are only used when asked for explicitly
+	if awsFromEnv {
+		awsAccessKey = envDefault(awsAccessKey, "AWS_ACCESS_KEY_ID")
+		awsSecretKey = envDefault(awsSecretKey, "AWS_SECRET_ACCESS_KEY")
+		awsSessionToken = envDefault(awsSessionToken, "AWS_SESSION_TOKEN")
+	}
+	if azureFromEnv {
+		azureAccount = envDefault(azureAccount, "AZURE_STORAGE_ACCOUNT")
+		azureAccountKey = envDefault(azureAccountKey, "AZURE_STORAGE_KEY")
+	}
+
+	if firebaseAPIKey != "" {
+		if firebaseEmail != "" {
+			worklog.Printf("Signing in to Firebase as %s.", firebaseEmail)
+		} else {
+			worklog.Printf("Signing in to Firebase anonymously.")
+		}
+		token, err := auth.FirebaseSignIn(paginator.Client, firebaseAuthURL, firebaseAPIKey, firebaseEmail, firebasePassword)
+		if err != nil {
+			log.Fatalf("Failed to sign in to Firebase: %s", err)
+		}
+		paginator.Client.Transport = &auth.TokenTransport{
+			Token:  token,
+			Scheme: "Firebase",
+			Hosts:  auth.FirebaseStorageHosts,
+			Base:   paginator.Client.Transport,
+		}
+	}
+	// Installed after the Firebase transport so it wraps it, letting the
+	// Firebase ID token replace the bearer token on Firebase Storage hosts
+	if bearerToken != "" {
+		worklog.Printf("Attaching bearer token to Google Cloud Storage and Firebase requests.")
+		paginator.Client.Transport = &auth.TokenTransport{
+			Token: bearerToken,
+			Hosts: auth.GoogleStorageHosts,
+			Base:  paginator.Client.Transport,
+		}
+	}
+	if azureAccountKey != "" {
+		account := azureAccount
+		if account == "" {
+			account = azureAccountFromURL(url)
+		}
+		if account == "" {
+			log.Fatalf("An Azure storage account name is required to use an account key.")
+		}
+		log.Printf("Signing requests to Azure storage account %s with Shared Key authorization.", account)
+		paginator.Client.Transport = &auth.SharedKeyTransport{
+			Account: account,
+			Key:     azureAccountKey,
+			Base:    paginator.Client.Transport,
+		}
+	}
+	if awsAccessKey != "" || awsSecretKey != "" {
+		if awsAccessKey == "" || awsSecretKey == "" {
+			log.Fatalf("Both an AWS access key and secret key are required to sign requests.")
+		}
+		log.Printf("Signing requests to AWS and S3 compatible input hosts with AWS access key %s.", awsAccessKey)
+		paginator.Client.Transport = &auth.SigV4Transport{
+			AccessKey:    awsAccessKey,
+			SecretKey:    awsSecretKey,
+			SessionToken: awsSessionToken,
+			Region:       awsRegion,
+			Service:      "s3",
+			Hosts:        s3InputHosts(),
+			Base:         paginator.Client.Transport,
+		}
+	}
+}
+
+// Returns the hosts of input URLs that point to generic S3 compatible
+// buckets or endpoints, so requests to them are signed along with those
+// to AWS. Other providers are never sent AWS credentials.
+func s3InputHosts() []string {
+	// Unreadable input files are reported when the inputs are indexed
+	inputs, _ := readInputs()
+
+	var hosts []string
+	for _, in := range inputs {
+		b, err := bucket.ParseURL(in)
+		if err != nil {
+			continue
+		}
+		if _, ok := b.(bucket.S3Bucket); !ok {
+			continue
+		}
+		urlData, err := neturl.Parse(in)
+		if err != nil {
+			continue
+		}
+		hosts = append(hosts, strings.ToLower(urlData.Hostname()))
+	}
+	return hosts
+}
+
+// Returns the value if set, otherwise the environment variable.
+func envDefault(value string, variable string) string {
+	if value != "" {
+		return value
+	}
+	return os.Getenv(variable)
+}
+
+// Returns the Azure storage account an input URL refers to, if any.
+func azureAccountFromURL(input string) string {
+	urlData, err := neturl.Parse(input)
+	if err != nil {
+		return ""
+	}
+	host := strings.ToLower(urlData.Hostname())
+	if !strings.HasSuffix(host, ".core.windows.net") {
+		return ""
+	}
+	return strings.Split(host, ".")[0]
+}
+
+// Returns the input URLs given by --input or --url, exiting if neither
+// was given or the input file can't be read.
+func inputURLs() []string {
+	inputs, err := readInputs()
+	if err != nil {
+		log.Fatalf("Failed to open input file: %s", err)
+	}
+	if len(inputs) == 0 && input == "" {
+		log.Fatalf("A --url or --input is required.")
+	}
+	return inputs
+}
+
+// Returns the input URLs given by --input or --url. The input file is
+// only read once.
+func readInputs() ([]string, error) {
+	if inputsRead {
+		return loadedInputs, nil
+	}
+	var inputs []string
+	if input != "" {
+		file, err := os.Open(input)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			inputs = append(inputs, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	} else if url != "" {
+		inputs = append(inputs, url)
+	}
+	loadedInputs, inputsRead = inputs, true
+	return inputs, nil
+}
+
+// Resolves an input URL to the buckets it refers to. If the URL points at a
+// service hosting multiple buckets, such as an Azure storage account or the
+// root of an S3 compatible endpoint, the buckets it hosts are discovered
+// and returned.
+func ResolveBuckets(input string) ([]bucket.Bucket, error) {
+	s, err := bucket.ParseServiceURL(input)
+	if err != nil {
+		return nil, err
+	}
+	if s != nil {
+		logWarnings(s.Name(), s)
+		worklog.Printf("Discovering buckets in %s.", s.Name())
+		buckets, err := paginator.Discover(s)
+		if err != nil {
+			return nil, err
+		}
+		if as, ok := s.(bucket.AmbiguousService); len(buckets) == 0 && ok && as.Ambiguous() {
+			// The URL is a bucket rather than a service root
+			worklog.Printf("No buckets listed by %s, treating it as a bucket.", s.Name())
+			return resolveBucket(input)
+		}
+		if len(buckets) == 0 {
+			return nil, fmt.Errorf("no public buckets found in %s", s.Name())
+		}
+		worklog.Printf("Discovered %d buckets in %s.", len(buckets), s.Name())
+		for i, b := range buckets {
+			buckets[i] = configureBucket(b)
+		}
+		return buckets, nil
+	}
+
+	return resolveBucket(input)
+}
+
+// Resolves an input URL to a single bucket.
+func resolveBucket(input string) ([]bucket.Bucket, error) {
+	b, err := bucket.ParseURL(input)
+	if err != nil {
+		return nil, err
+	}
+	logWarnings(b.Name(), b)
+	if region := bucketRegion(b); region != "" {
+		worklog.Printf("Fingerprinted %s in region %s.", b.Name(), region)
+	}
+	return []bucket.Bucket{configureBucket(b)}, nil
+}
+
+// Applies the listing mode flags to a bucket.
+func configureBucket(b bucket.Bucket) bucket.Bucket {
+	if versions {
+		vb, ok := b.(bucket.VersionedBucket)
+		if !ok {
+			log.Printf("Warning for %s: listing versions isn't supported, listing current objects only.", b.Name())
+			return b
+		}
+		b = vb.WithVersions()
+	}
+	if len(azureInclude) > 0 {
+		ab, ok := b.(bucket.AzureStorageBucket)
+		if !ok {
+			log.Printf("Warning for %s: --azure-include only applies to Azure containers.", b.Name())
+			return b
+		}
+		ab, err := ab.WithInclude(azureInclude)
+		if err != nil {
+			log.Fatalf("Invalid --azure-include: %s", err)
+		}
+		b = ab
+	}
+	return b
+}
+
+// Logs any problems reported by a bucket or service.
+func logWarnings(name string, v interface{}) {
+	if w, ok := v.(bucket.Warner); ok {
+		for _, warning := range w.Warnings() {
+			log.Printf("Warning for %s: %s", name, warning)
+		}
+	}
+}
+
+// Type jsonRecord is a line of output in the json format.
+type jsonRecord struct {
+	bucket.Object
+	URL    string `json:"url"`
+	Region string `json:"region,omitempty"`
+}
+
+// The maximum number of times a listing may be redirected to another endpoint.
+const maxRedirects = 3
+
+// Returns the bucket used to build output URLs, without credentials if requested.
+func outputBucket(b bucket.Bucket) bucket.Bucket {
+	if cb, ok := b.(bucket.CredentialBucket); ok && stripCreds {
+		return cb.WithoutCredentials()
+	}
+	return b
+}
+
+// Returns the region a bucket is hosted in, if known.
+func bucketRegion(b bucket.Bucket) string {
+	if rb, ok := b.(bucket.RegionalBucket); ok {
+		return rb.Region()
+	}
+	return ""
+}
+
 func IndexBucket(b bucket.Bucket, keyCounter *int64, startedBuckets *int64, completedBuckets *int64, single bool) {
 	// Prepare state
 	// var keys []string
@@ -170,6 +496,7 @@ func IndexBucket(b bucket.Bucket, keyCounter *int64, startedBuckets *int64, comp
 	if single {
 		paginationKey = startkey
 	}
+	lastKey := ""
 	first := true
 	filename := ""
 
@@ -213,48 +540,135 @@ func IndexBucket(b bucket.Bucket, keyCounter *int64, startedBuckets *int64, comp
 			if ok && single {
 				fmt.Println("")
 				log.Printf("Interrupted. Last pagination key: %s\n", paginationKey)
+				if lastKey != "" && lastKey != paginationKey {
+					// S3 continuation tokens can't be used with --startkey, but the last key can.
+					log.Printf("Last key written: %s\n", lastKey)
+				}
 			}
 			return
 		}
 	}()
 	defer close(c)
 
+	// Build output URLs without credentials if requested
+	out := outputBucket(b)
+
 	worklog.Printf("Counting keys in %s.", b.Name())
 	if paginationKey != "" {
 		worklog.Printf("Starting from key %s.", paginationKey)
 	}
 
-	// Pull each page of the bucket
-	for paginationKey != "" || first == true {
-		first = false
-		newKeys, newPaginationKey, err := paginator.Paginate(b, paginationKey)
-		if err != nil {
-			fmt.Println("")
-			log.Printf("Error during pagination: %s", err)
-			return
+	// Write each object to the file as we receive it
+	// This way even if our program is cancelled, we can resume
+	// from the most recent key.
+	writeObjects := func(objects []bucket.Object) error {
+		if mb, ok := b.(bucket.MetadataBucket); ok && fetchMetadata {
+			var errs []error
+			objects, errs = paginator.FetchMetadata(mb, objects, metadataConcurrency)
+			for _, err := range errs {
+				worklog.Printf("Error fetching metadata: %s", err)
+			}
 		}
-		// Write each key to the file as we receive it
-		// This way even if our program is cancelled, we can resume
-		// from the most recent key.
-		for _, k := range newKeys {
+		for _, o := range objects {
+			// Directories, delete markers and soft-deleted blobs can't be downloaded, so only the json format includes them.
+			if (o.Directory || o.DeleteMarker || o.Deleted) && format != "json" {
+				continue
+			}
+			// Download tokens grant access to the object, so they are stripped along with URL credentials.
+			if stripCreds {
+				o.DownloadToken = ""
+			}
 			// keys = append(keys, k) // Storing all these keys leaks memory for no real reason.
 			atomic.AddInt64(keyCounter, 1)
 
 			var writestr string
 			switch format {
 			case "keys":
-				writestr = fmt.Sprintf("%s\n", k)
+				writestr = fmt.Sprintf("%s\n", o.Key)
 			case "csv":
-				writestr = fmt.Sprintf("%s,%s\n", k, b.ResourceURL(k))
+				writestr = fmt.Sprintf("%s,%s\n", o.Key, bucket.ObjectURL(out, o))
+			case "json":
+				line, err := json.Marshal(jsonRecord{Object: o, URL: bucket.ObjectURL(out, o), Region: bucketRegion(out)})
+				if err != nil {
+					return fmt.Errorf("error encoding object: %s", err)
+				}
+				writestr = fmt.Sprintf("%s\n", line)
 			default:
-				writestr = fmt.Sprintf("%s\n", b.ResourceURL(k))
+				writestr = fmt.Sprintf("%s\n", bucket.ObjectURL(out, o))
 			}
 
 			_, writeErr := writer.WriteString(writestr)
 			if writeErr != nil {
-				log.Printf("Error during write: %s", writeErr)
-				return
+				return fmt.Errorf("error during write: %s", writeErr)
+			}
+			lastKey = o.Key
+		}
+		return nil
+	}
+
+	// Walk the folder hierarchy if requested
+	if pb, ok := b.(bucket.PrefixBucket); ok && recursive {
+		if paginationKey != "" {
+			log.Printf("Ignoring start key %s since folders are traversed concurrently.", paginationKey)
+		}
+		var writeErr error
+		errs := paginator.Traverse(pb, traversalConcurrency, func(objects []bucket.Object) {
+			if writeErr == nil {
+				writeErr = writeObjects(objects)
 			}
+		})
+		for _, err := range errs {
+			log.Printf("Error during traversal: %s", err)
+		}
+		if writeErr != nil {
+			log.Printf("%s", writeErr)
+		}
+		return
+	}
+
+	// Pull each page of the bucket
+	guard := paginator.NewGuard(b)
+	redirects := 0
+	for paginationKey != "" || first == true {
+		first = false
+		newObjects, newPaginationKey, err := paginator.Paginate(b, paginationKey)
+		var redirect *paginator.RedirectError
+		if errors.As(err, &redirect) && redirects < maxRedirects {
+			// Retry the same page against the endpoint redirected to
+			redirects++
+			b = redirect.Bucket
+			out = outputBucket(b)
+			guard = paginator.NewGuard(b)
+			if region := bucketRegion(b); region != "" {
+				worklog.Printf("%s is in region %s, listing through %s.", b.Name(), region, b.URL())
+			} else {
+				worklog.Printf("%s redirected to %s.", b.Name(), b.URL())
+			}
+			first = paginationKey == ""
+			continue
+		}
+		if errors.Is(err, bucket.ErrEmptyTruncatedPage) {
+			newObjects = nil
+			newPaginationKey, err = guard.Recover(err)
+		} else if err == nil {
+			newPaginationKey, err = guard.Check(newObjects, newPaginationKey)
+		}
+		var stall *paginator.StallError
+		if errors.As(err, &stall) && stall.Recovered {
+			// The stalled page is skipped, since the listing resumes after
+			// the last key of the previous page and lists it again
+			worklog.Printf("%s", err)
+			paginationKey = newPaginationKey
+			continue
+		}
+		if err != nil {
+			fmt.Println("")
+			log.Printf("Error during pagination: %s", err)
+			return
+		}
+		if err := writeObjects(newObjects); err != nil {
+			log.Printf("%s", err)
+			return
 		}
 		paginationKey = newPaginationKey
 	}
@@ -265,7 +679,7 @@ func WritePaginatorStatus(start time.Time, keys *int64, startedBuckets *int64, c
 	elapsed := time.Since(start)
 
 	// Move cursor up and clear line
-	fmt.Printf("%c[%dA", esc)
+	fmt.Printf("%c[%dA", esc, 1)
 	fmt.Printf("%c[2K\r", esc)
 	fmt.Printf("\r\r[bucketbuster] Elapsed: %s, Total keys: %v, Buckets started: %v, Buckets completed: %v", elapsed.Round(1*time.Second), atomic.LoadInt64(keys), atomic.LoadInt64(startedBuckets), atomic.LoadInt64(completedBuckets))
 }
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"github.com/shellhazard/bucketbuster/internal/takeover"
+	"github.com/spf13/cobra"
+)
+
+// The time allowed to resolve and request each hostname.
+const takeoverTimeout = 30 * time.Second
+
+var takeoverCmd = &cobra.Command{
+	Use:   "takeover",
+	Short: "Detect custom domains pointing to storage buckets that no longer exist.",
+	Long: `Resolves each hostname, and if it points to a storage provider, checks
+whether the bucket or account behind it still exists. Writes one JSON
+finding per hostname, flagging dangling buckets whose names can be
+claimed to take over the domain.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		inputs := inputURLs()
+
+		// Write findings to the outfile if one was given
+		var w io.Writer = os.Stdout
+		if outfile != "" {
+			f, err := os.Create(outfile)
+			if err != nil {
+				log.Fatalf("Error creating outfile: %s", err)
+			}
+			defer f.Close()
+			w = f
+		}
+		encoder := json.NewEncoder(w)
+
+		checker := takeover.Checker{}
+		for _, in := range inputs {
+			ctx, cancel := context.WithTimeout(context.Background(), takeoverTimeout)
+			finding := checker.Check(ctx, in)
+			cancel()
+			switch {
+			case finding.Claimable:
+				log.Printf("%s points to a missing %s bucket that can be claimed: %s", finding.Host, finding.Provider, finding.BucketName)
+			case finding.Dangling:
+				log.Printf("%s points to a missing %s bucket: %s", finding.Host, finding.Provider, finding.BucketName)
+			case finding.Error != "":
+				worklog.Printf("Error checking %s: %s", in, finding.Error)
+			}
+			if err := encoder.Encode(finding); err != nil {
+				log.Fatalf("Error writing finding: %s", err)
+			}
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(takeoverCmd)
+}
@@ -2,4 +2,4 @@ module github.com/shellhazard/bucketbuster
 
 go 1.16
 
-require github.com/spf13/cobra v1.1.3 // indirect
+require github.com/spf13/cobra v1.1.3
@@ -0,0 +1,177 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"strings"
+
+	"github.com/shellhazard/bucketbuster/bucket"
+	"github.com/shellhazard/bucketbuster/internal/paginator"
+)
+
+// Type Report describes which configuration endpoints of a bucket are readable.
+type Report struct {
+	// The name of the bucket.
+	Bucket string `json:"bucket"`
+
+	// The URL of the bucket.
+	URL string `json:"url"`
+
+	// The result of each check.
+	Checks []Result `json:"checks"`
+
+	// The result of the write probe, if one was made.
+	Write *WriteResult `json:"write,omitempty"`
+}
+
+// Type Result is the outcome of probing a single configuration endpoint.
+type Result struct {
+	// The name of the configuration, e.g. acl or policy.
+	Name string `json:"name"`
+
+	// The URL probed.
+	URL string `json:"url"`
+
+	// The HTTP status code returned, or 0 if the request failed.
+	Status int `json:"status"`
+
+	// Set if the configuration could be read.
+	Readable bool `json:"readable"`
+
+	// Provider response headers, for configurations returned in headers.
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// The decoded response body. XML documents are converted to nested
+	// objects keyed by element name.
+	Content interface{} `json:"content,omitempty"`
+
+	// The reason the request failed, if it did.
+	Error string `json:"error,omitempty"`
+}
+
+// Probes each configuration endpoint of the bucket with the client and
+// returns a report of which are readable. Buckets without configuration
+// endpoints return an empty report.
+func Audit(client *http.Client, b bucket.Bucket) Report {
+	report := Report{
+		Bucket: b.Name(),
+		URL:    b.URL(),
+		Checks: []Result{},
+	}
+	if ab, ok := b.(bucket.AuditableBucket); ok {
+		for _, check := range ab.AuditChecks() {
+			report.Checks = append(report.Checks, probe(client, check))
+		}
+	}
+	return report
+}
+
+// Probes a single configuration endpoint.
+func probe(client *http.Client, check bucket.AuditCheck) Result {
+	result := Result{
+		Name: check.Name,
+		URL:  check.URL,
+	}
+	resp, body, err := paginator.Send(client, http.MethodGet, check.URL, nil, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Status = resp.StatusCode
+	result.Readable = resp.StatusCode == http.StatusOK
+	if check.Headers && result.Readable {
+		result.Headers = providerHeaders(resp.Header)
+	}
+	result.Content = decode(resp.Header.Get("Content-Type"), body)
+	return result
+}
+
+// Returns the provider specific response headers, such as x-ms-blob-public-access.
+func providerHeaders(header http.Header) map[string]string {
+	headers := map[string]string{}
+	for name, values := range header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-ms-") || strings.HasPrefix(lower, "x-amz-") || strings.HasPrefix(lower, "x-goog-") {
+			headers[lower] = strings.Join(values, ",")
+		}
+	}
+	return headers
+}
+
+// Decodes a JSON or XML response body, falling back to the raw text.
+// Returns nil for an empty body.
+func decode(contentType string, body []byte) interface{} {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 {
+		return nil
+	}
+	var v interface{}
+	if strings.Contains(contentType, "json") || trimmed[0] == '{' || trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &v); err == nil {
+			return v
+		}
+	}
+	if strings.Contains(contentType, "xml") || trimmed[0] == '<' {
+		if v, err := decodeXML(trimmed); err == nil {
+			return v
+		}
+	}
+	return string(trimmed)
+}
+
+// Converts an XML document into nested maps keyed by element name. Elements
+// without children become strings, and repeated elements become slices.
+func decodeXML(data []byte) (interface{}, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			v, err := decodeElement(decoder)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{start.Name.Local: v}, nil
+		}
+	}
+}
+
+// Decodes the contents of the element most recently started.
+func decodeElement(decoder *xml.Decoder) (interface{}, error) {
+	children := map[string]interface{}{}
+	var text strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			v, err := decodeElement(decoder)
+			if err != nil {
+				return nil, err
+			}
+			name := t.Name.Local
+			switch existing := children[name].(type) {
+			case nil:
+				children[name] = v
+			case []interface{}:
+				children[name] = append(existing, v)
+			default:
+				children[name] = []interface{}{existing, v}
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if len(children) == 0 {
+				return strings.TrimSpace(text.String()), nil
+			}
+			return children, nil
+		}
+	}
+}
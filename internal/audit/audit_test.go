@@ -0,0 +1,156 @@
+package audit
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/shellhazard/bucketbuster/bucket"
+)
+
+type testBucket struct {
+	bucket.S3Bucket
+	checks []bucket.AuditCheck
+}
+
+func (b testBucket) AuditChecks() []bucket.AuditCheck {
+	return b.checks
+}
+
+func TestAudit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.RawQuery {
+		case "acl":
+			w.Header().Set("Content-Type", "application/xml")
+			fmt.Fprint(w, `<AccessControlPolicy><Owner><ID>abc</ID></Owner><AccessControlList>
+				<Grant><Permission>READ</Permission></Grant><Grant><Permission>WRITE</Permission></Grant>
+			</AccessControlList></AccessControlPolicy>`)
+		case "policy":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"Version":"2012-10-17"}`)
+		case "properties":
+			w.Header().Set("x-ms-blob-public-access", "container")
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `<Error><Code>AccessDenied</Code></Error>`)
+		}
+	}))
+	defer srv.Close()
+
+	b := testBucket{
+		S3Bucket: bucket.NewS3Bucket(srv.URL, "example"),
+		checks: []bucket.AuditCheck{
+			{Name: "acl", URL: srv.URL + "?acl"},
+			{Name: "policy", URL: srv.URL + "?policy"},
+			{Name: "properties", URL: srv.URL + "?properties", Headers: true},
+			{Name: "cors", URL: srv.URL + "?cors"},
+		},
+	}
+	report := Audit(srv.Client(), b)
+
+	want := []Result{
+		{Name: "acl", URL: srv.URL + "?acl", Status: 200, Readable: true, Content: map[string]interface{}{
+			"AccessControlPolicy": map[string]interface{}{
+				"Owner": map[string]interface{}{"ID": "abc"},
+				"AccessControlList": map[string]interface{}{"Grant": []interface{}{
+					map[string]interface{}{"Permission": "READ"},
+					map[string]interface{}{"Permission": "WRITE"},
+				}},
+			},
+		}},
+		{Name: "policy", URL: srv.URL + "?policy", Status: 200, Readable: true, Content: map[string]interface{}{"Version": "2012-10-17"}},
+		{Name: "properties", URL: srv.URL + "?properties", Status: 200, Readable: true, Headers: map[string]string{"x-ms-blob-public-access": "container"}},
+		{Name: "cors", URL: srv.URL + "?cors", Status: 403, Content: map[string]interface{}{
+			"Error": map[string]interface{}{"Code": "AccessDenied"},
+		}},
+	}
+	if !reflect.DeepEqual(report.Checks, want) {
+		t.Errorf("Audit returned %+v, want %+v", report.Checks, want)
+	}
+}
+
+// Serves a bucket backed by a map, recording each upload.
+func canaryServer(objects map[string][]byte, headStatus int, uploads *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Path
+		switch r.Method {
+		case http.MethodHead:
+			if headStatus != 0 {
+				w.WriteHeader(headStatus)
+			} else if _, ok := objects[key]; !ok {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case http.MethodPut:
+			*uploads++
+			if _, ok := objects[key]; ok && r.Header.Get("If-None-Match") == "*" {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+			body, _ := io.ReadAll(r.Body)
+			objects[key] = body
+		case http.MethodGet:
+			w.Write(objects[key])
+		case http.MethodDelete:
+			delete(objects, key)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+}
+
+type probeBucket struct {
+	bucket.S3Bucket
+	conditional bool
+}
+
+func (b probeBucket) WriteProbe(key string) bucket.WriteProbe {
+	probe := b.S3Bucket.WriteProbe(key)
+	probe.Conditional = b.conditional
+	return probe
+}
+
+func TestProbeWrite(t *testing.T) {
+	tests := []struct {
+		name        string
+		existing    bool
+		headStatus  int
+		conditional bool
+		wantUploads int
+		want        WriteResult
+	}{
+		{"writable", false, 0, true, 1, WriteResult{Writable: true, Verified: true, Deleted: true, ExistsStatus: 404, UploadStatus: 200, ReadStatus: 200, DeleteStatus: 204}},
+		{"existing key", true, 0, true, 0, WriteResult{ExistsStatus: 200, Error: "canary key already exists"}},
+		{"unconfirmed conditional", false, http.StatusForbidden, true, 1, WriteResult{Writable: true, Verified: true, Deleted: true, ExistsStatus: 403, UploadStatus: 200, ReadStatus: 200, DeleteStatus: 204}},
+		{"unconfirmed unconditional", false, http.StatusForbidden, false, 0, WriteResult{ExistsStatus: 403, Error: "existence check returned status 403, not uploading since the upload can't be made conditional"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key := CanaryKey()
+			objects := map[string][]byte{}
+			if test.existing {
+				objects["/"+key] = []byte("original")
+			}
+			uploads := 0
+			srv := canaryServer(objects, test.headStatus, &uploads)
+			defer srv.Close()
+
+			b := probeBucket{S3Bucket: bucket.NewS3Bucket(srv.URL, "example"), conditional: test.conditional}
+			result := ProbeWrite(srv.Client(), b, key)
+			test.want.Key = key
+			if result != test.want {
+				t.Errorf("ProbeWrite returned %+v, want %+v", result, test.want)
+			}
+			if uploads != test.wantUploads {
+				t.Errorf("ProbeWrite uploaded %d times, want %d", uploads, test.wantUploads)
+			}
+			if test.existing && string(objects["/"+key]) != "original" {
+				t.Error("ProbeWrite overwrote an existing object")
+			}
+			if !test.existing && len(objects) != 0 {
+				t.Error("ProbeWrite left the canary object behind")
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/shellhazard/bucketbuster/bucket"
+	"github.com/shellhazard/bucketbuster/internal/paginator"
+)
+
+// Type WriteResult is the outcome of writing a canary object to a bucket.
+type WriteResult struct {
+	// The key of the canary object.
+	Key string `json:"key"`
+
+	// Set if the upload succeeded.
+	Writable bool `json:"writable"`
+
+	// Set if the object could be read back with the uploaded content.
+	Verified bool `json:"verified"`
+
+	// Set if the object was deleted after the upload.
+	Deleted bool `json:"deleted"`
+
+	// The status codes returned by the existence check, upload, read and delete requests.
+	ExistsStatus int `json:"existsStatus,omitempty"`
+	UploadStatus int `json:"uploadStatus,omitempty"`
+	ReadStatus   int `json:"readStatus,omitempty"`
+	DeleteStatus int `json:"deleteStatus,omitempty"`
+
+	// The reason the probe stopped early, if it did.
+	Error string `json:"error,omitempty"`
+}
+
+// Returns a unique key for a canary object.
+func CanaryKey() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return fmt.Sprintf("bucketbuster-canary-%s.txt", hex.EncodeToString(id))
+}
+
+// Tests whether the bucket is writable by uploading a small canary object
+// with the specified key, reading it back and deleting it. The upload is
+// skipped if the key exists, or if its existence can't be confirmed and the
+// provider can't reject uploads to existing keys, so existing objects are
+// never overwritten.
+func ProbeWrite(client *http.Client, b bucket.WriteProbeBucket, key string) WriteResult {
+	probe := b.WriteProbe(key)
+	result := WriteResult{Key: key}
+	content := []byte(fmt.Sprintf("bucketbuster write canary %s\n", time.Now().UTC().Format(time.RFC3339)))
+
+	// Make sure the key is free
+	resp, _, err := paginator.Send(client, http.MethodHead, probe.ExistsURL, nil, nil)
+	if err != nil {
+		result.Error = fmt.Sprintf("existence check failed: %s", err)
+		return result
+	}
+	result.ExistsStatus = resp.StatusCode
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+	case resp.StatusCode < 300:
+		result.Error = "canary key already exists"
+		return result
+	case !probe.Conditional:
+		result.Error = fmt.Sprintf("existence check returned status %d, not uploading since the upload can't be made conditional", resp.StatusCode)
+		return result
+	}
+
+	// Upload the canary
+	resp, _, err = paginator.Send(client, probe.UploadMethod, probe.UploadURL, probe.UploadHeaders, content)
+	if err != nil {
+		result.Error = fmt.Sprintf("upload failed: %s", err)
+		return result
+	}
+	result.UploadStatus = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return result
+	}
+	result.Writable = true
+
+	// Read it back
+	resp, body, err := paginator.Send(client, http.MethodGet, probe.ReadURL, nil, nil)
+	if err == nil {
+		result.ReadStatus = resp.StatusCode
+		result.Verified = resp.StatusCode == http.StatusOK && bytes.Equal(body, content)
+	}
+
+	// Clean up
+	resp, _, err = paginator.Send(client, http.MethodDelete, probe.DeleteURL, nil, nil)
+	if err != nil {
+		result.Error = fmt.Sprintf("delete failed: %s", err)
+		return result
+	}
+	result.DeleteStatus = resp.StatusCode
+	result.Deleted = resp.StatusCode >= 200 && resp.StatusCode < 300
+	return result
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Hosts serving Google Cloud Storage and Firebase Storage.
+var GoogleStorageHosts = []string{
+	"storage.googleapis.com",
+	"www.googleapis.com",
+	"firebasestorage.googleapis.com",
+}
+
+// Hosts serving Firebase Storage.
+var FirebaseStorageHosts = []string{
+	"firebasestorage.googleapis.com",
+}
+
+// Type TokenTransport is an http.RoundTripper that attaches a token to
+// requests sent to a set of hosts. Requests to other hosts are sent
+// unmodified so the token isn't leaked to unrelated services.
+type TokenTransport struct {
+	// The token to attach.
+	Token string
+
+	// The authorization scheme, e.g. Bearer. Defaults to Bearer.
+	Scheme string
+
+	// The hosts to attach the token for. Subdomains of each host also match.
+	Hosts []string
+
+	// The transport used to send requests. Defaults to http.DefaultTransport.
+	Base http.RoundTripper
+}
+
+// Attaches the token to a copy of the request if its host matches and
+// sends it with the base transport.
+func (t *TokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if !t.matches(req.URL.Hostname()) {
+		return base.RoundTrip(req)
+	}
+	scheme := t.Scheme
+	if scheme == "" {
+		scheme = "Bearer"
+	}
+	authed := req.Clone(req.Context())
+	authed.Header.Set("Authorization", fmt.Sprintf("%s %s", scheme, t.Token))
+	return base.RoundTrip(authed)
+}
+
+// Returns true if the host is one of the transport's hosts or a subdomain of one.
+func (t *TokenTransport) matches(host string) bool {
+	host = strings.ToLower(host)
+	for _, h := range t.Hosts {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestTokenTransportMatchesHosts(t *testing.T) {
+	transport := TokenTransport{Hosts: GoogleStorageHosts}
+	tests := map[string]bool{
+		"storage.googleapis.com":             true,
+		"example.storage.googleapis.com":     true,
+		"www.googleapis.com":                 true,
+		"FirebaseStorage.googleapis.com":     true,
+		"identitytoolkit.googleapis.com":     false,
+		"example.s3.amazonaws.com":           false,
+		"storage.googleapis.com.example.net": false,
+	}
+	for host, want := range tests {
+		if got := transport.matches(host); got != want {
+			t.Errorf("matches(%q) = %v, want %v", host, got, want)
+		}
+	}
+}
+
+func TestTokenTransportChain(t *testing.T) {
+	var sent *http.Request
+	base := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		sent = req
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})
+
+	// The innermost transport sets the header last, so its token wins on
+	// hosts both transports match
+	transport := &TokenTransport{
+		Token: "oauth",
+		Hosts: GoogleStorageHosts,
+		Base: &TokenTransport{
+			Token:  "idtoken",
+			Scheme: "Firebase",
+			Hosts:  FirebaseStorageHosts,
+			Base:   base,
+		},
+	}
+	tests := map[string]string{
+		"https://firebasestorage.googleapis.com/v0/b/example/o": "Firebase idtoken",
+		"https://storage.googleapis.com/storage/v1/b/example/o": "Bearer oauth",
+		"https://example.s3.amazonaws.com/":                     "",
+	}
+	for target, want := range tests {
+		req, _ := http.NewRequest(http.MethodGet, target, nil)
+		if _, err := transport.RoundTrip(req); err != nil {
+			t.Fatalf("RoundTrip(%q) failed: %s", target, err)
+		}
+		if got := sent.Header.Get("Authorization"); got != want {
+			t.Errorf("RoundTrip(%q) sent Authorization %q, want %q", target, got, want)
+		}
+		if req.Header.Get("Authorization") != "" {
+			t.Errorf("RoundTrip(%q) modified the original request", target)
+		}
+	}
+}
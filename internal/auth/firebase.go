@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// The default Identity Toolkit endpoint used by Firebase Authentication.
+const DefaultIdentityToolkitURL = "https://identitytoolkit.googleapis.com/v1"
+
+// Type firebaseSignInResponse is a helper type for storing JSON data.
+type firebaseSignInResponse struct {
+	IDToken string `json:"idToken"`
+	LocalID string `json:"localId"`
+	Error   *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Signs in to a Firebase project and returns an ID token that can be used
+// to access Firebase Storage. If email is empty, an anonymous account is
+// created instead, which only works if anonymous sign-in is enabled.
+// The endpoint is the base Identity Toolkit URL, e.g. DefaultIdentityToolkitURL.
+func FirebaseSignIn(client *http.Client, endpoint string, apiKey string, email string, password string) (string, error) {
+	method := "accounts:signUp"
+	payload := map[string]interface{}{
+		"returnSecureToken": true,
+	}
+	if email != "" {
+		method = "accounts:signInWithPassword"
+		payload["email"] = email
+		payload["password"] = password
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	target := fmt.Sprintf("%s/%s?key=%s", strings.TrimSuffix(endpoint, "/"), method, url.QueryEscape(apiKey))
+	resp, err := client.Post(target, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	var result firebaseSignInResponse
+	if err := json.Unmarshal(data, &result); err != nil {
+		return "", fmt.Errorf("unexpected sign-in response (status %d): %s", resp.StatusCode, err)
+	}
+	if result.Error != nil {
+		return "", fmt.Errorf("sign-in failed: %s", result.Error.Message)
+	}
+	if result.IDToken == "" {
+		return "", fmt.Errorf("sign-in response (status %d) didn't include an ID token", resp.StatusCode)
+	}
+	return result.IDToken, nil
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFirebaseSignIn(t *testing.T) {
+	tests := []struct {
+		name       string
+		email      string
+		wantMethod string
+		status     int
+		response   string
+		wantToken  string
+		wantErr    string
+	}{
+		{
+			name:       "anonymous",
+			wantMethod: "accounts:signUp",
+			status:     http.StatusOK,
+			response:   `{"idToken":"anon-token","localId":"abc"}`,
+			wantToken:  "anon-token",
+		},
+		{
+			name:       "password",
+			email:      "user@example.com",
+			wantMethod: "accounts:signInWithPassword",
+			status:     http.StatusOK,
+			response:   `{"idToken":"user-token","localId":"def"}`,
+			wantToken:  "user-token",
+		},
+		{
+			name:       "error payload",
+			wantMethod: "accounts:signUp",
+			status:     http.StatusBadRequest,
+			response:   `{"error":{"code":400,"message":"ADMIN_ONLY_OPERATION"}}`,
+			wantErr:    "ADMIN_ONLY_OPERATION",
+		},
+		{
+			name:       "missing token",
+			wantMethod: "accounts:signUp",
+			status:     http.StatusOK,
+			response:   `{"localId":"abc"}`,
+			wantErr:    "didn't include an ID token",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got := strings.TrimPrefix(r.URL.Path, "/v1/"); got != test.wantMethod {
+					t.Errorf("requested method %q, want %q", got, test.wantMethod)
+				}
+				if got := r.URL.Query().Get("key"); got != "api-key" {
+					t.Errorf("requested key %q, want %q", got, "api-key")
+				}
+				var payload map[string]interface{}
+				json.NewDecoder(r.Body).Decode(&payload)
+				if payload["returnSecureToken"] != true {
+					t.Errorf("payload %v didn't request a secure token", payload)
+				}
+				if test.email != "" && (payload["email"] != test.email || payload["password"] != "hunter2") {
+					t.Errorf("payload %v didn't include the credentials", payload)
+				}
+				w.WriteHeader(test.status)
+				fmt.Fprint(w, test.response)
+			}))
+			defer srv.Close()
+
+			token, err := FirebaseSignIn(srv.Client(), srv.URL+"/v1/", "api-key", test.email, "hunter2")
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("FirebaseSignIn returned error %v, want one containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FirebaseSignIn failed: %s", err)
+			}
+			if token != test.wantToken {
+				t.Errorf("FirebaseSignIn returned token %q, want %q", token, test.wantToken)
+			}
+		})
+	}
+}
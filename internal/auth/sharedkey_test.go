@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSharedKeyStringToSign(t *testing.T) {
+	transport := SharedKeyTransport{
+		Account: "myaccount",
+		Key:     "c2VjcmV0",
+	}
+	req, err := http.NewRequest(http.MethodGet, "https://myaccount.blob.core.windows.net/mycontainer?restype=container&comp=list&include=snapshots&include=metadata", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := transport.Sign(req, now); err != nil {
+		t.Fatal(err)
+	}
+
+	want := strings.Join([]string{
+		"GET", "", "", "", "", "", "", "", "", "", "", "",
+		"x-ms-date:Sat, 02 Jan 2021 03:04:05 GMT",
+		"x-ms-version:" + DefaultAzureVersion,
+		"/myaccount/mycontainer",
+		"comp:list",
+		"include:metadata,snapshots",
+		"restype:container",
+	}, "\n")
+	if got := transport.stringToSign(req); got != want {
+		t.Errorf("unexpected string to sign:\n%s\nwant:\n%s", got, want)
+	}
+	if !strings.HasPrefix(req.Header.Get("Authorization"), "SharedKey myaccount:") {
+		t.Errorf("unexpected Authorization header %q", req.Header.Get("Authorization"))
+	}
+}
+
+func TestSharedKeyMatchesAccount(t *testing.T) {
+	transport := SharedKeyTransport{Account: "myaccount"}
+	tests := map[string]bool{
+		"myaccount.blob.core.windows.net":    true,
+		"myaccount.dfs.core.windows.net":     true,
+		"otheraccount.blob.core.windows.net": false,
+		"myaccount.example.com":              false,
+	}
+	for host, want := range tests {
+		if got := transport.matches(host); got != want {
+			t.Errorf("matches(%q) = %v, want %v", host, got, want)
+		}
+	}
+}
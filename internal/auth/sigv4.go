@@ -0,0 +1,284 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	sigV4Algorithm  = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat = "20060102T150405Z"
+	sigV4DateFormat = "20060102"
+)
+
+// Type SigV4Transport is an http.RoundTripper that signs requests to AWS
+// and a set of other S3 compatible hosts with AWS Signature Version 4.
+// Requests to other hosts are sent unsigned so the credentials aren't
+// leaked to unrelated services.
+type SigV4Transport struct {
+	// The access key ID.
+	AccessKey string
+
+	// The secret access key.
+	SecretKey string
+
+	// The session token for temporary credentials, if any.
+	SessionToken string
+
+	// The region to sign for. If empty, it is inferred from the host and
+	// falls back to us-east-1.
+	Region string
+
+	// The service to sign for, e.g. s3.
+	Service string
+
+	// Hosts to sign requests for in addition to AWS hosts, such as S3
+	// compatible endpoints. Subdomains of each host also match.
+	Hosts []string
+
+	// The transport used to send signed requests. Defaults to http.DefaultTransport.
+	Base http.RoundTripper
+
+	// Returns the signing time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// Signs a copy of the request if its host matches and sends it with the
+// base transport.
+func (t *SigV4Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if !t.matches(req.URL.Hostname()) {
+		return base.RoundTrip(req)
+	}
+	signed := req.Clone(req.Context())
+	now := time.Now
+	if t.Now != nil {
+		now = t.Now
+	}
+	if err := t.Sign(signed, now()); err != nil {
+		return nil, err
+	}
+	return base.RoundTrip(signed)
+}
+
+// Returns true if the host is an AWS host, one of the transport's hosts or
+// a subdomain of one.
+func (t *SigV4Transport) matches(host string) bool {
+	host = strings.ToLower(host)
+	if strings.HasSuffix(host, ".amazonaws.com") || strings.HasSuffix(host, ".amazonaws.com.cn") {
+		return true
+	}
+	for _, h := range t.Hosts {
+		h = strings.ToLower(h)
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	return false
+}
+
+// Signs the request in place, setting the X-Amz-Date and Authorization
+// headers along with X-Amz-Content-Sha256 for S3 and X-Amz-Security-Token
+// when a session token is set.
+func (t *SigV4Transport) Sign(req *http.Request, now time.Time) error {
+	now = now.UTC()
+	region := t.Region
+	if region == "" {
+		region = InferRegion(req.URL.Hostname())
+	}
+
+	payloadHash, err := hashBody(req)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("X-Amz-Date", now.Format(sigV4TimeFormat))
+	if t.Service == "s3" {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+	if t.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", t.SessionToken)
+	}
+
+	canonicalHeaders, signedHeaders := canonicalHeaders(req)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI(req.URL, t.Service),
+		canonicalQuery(req.URL),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", now.Format(sigV4DateFormat), region, t.Service)
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		now.Format(sigV4TimeFormat),
+		scope,
+		hashHex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+t.SecretKey), []byte(now.Format(sigV4DateFormat)))
+	key = hmacSHA256(key, []byte(region))
+	key = hmacSHA256(key, []byte(t.Service))
+	key = hmacSHA256(key, []byte("aws4_request"))
+	signature := hex.EncodeToString(hmacSHA256(key, []byte(stringToSign)))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, t.AccessKey, scope, signedHeaders, signature))
+	return nil
+}
+
+// Infers the AWS region from a host such as bucket.s3.eu-west-1.amazonaws.com,
+// returning us-east-1 if it can't be determined.
+func InferRegion(host string) string {
+	if region, ok := HostRegion(host); ok {
+		return region
+	}
+	return "us-east-1"
+}
+
+// Returns the region named by a host such as bucket.s3.eu-west-1.amazonaws.com.
+// Global, accelerated and dualstack accelerated endpoints serve buckets in
+// any region, so they don't name one.
+func HostRegion(host string) (string, bool) {
+	fragments := strings.Split(strings.ToLower(host), ".")
+	for i, fragment := range fragments {
+		if fragment != "amazonaws" || i == 0 {
+			continue
+		}
+		// AWS hosts carry the region directly before the domain
+		previous := fragments[i-1]
+		switch {
+		case previous == "s3" || previous == "s3-accelerate" || previous == "dualstack":
+			return "", false
+		case previous == "s3-external-1":
+			return "us-east-1", true
+		case strings.HasPrefix(previous, "s3-website-"):
+			return strings.TrimPrefix(previous, "s3-website-"), true
+		case strings.HasPrefix(previous, "s3-"):
+			return strings.TrimPrefix(previous, "s3-"), true
+		default:
+			return previous, true
+		}
+	}
+	// Other providers commonly use s3.<region>.<domain>.<tld>
+	for i, fragment := range fragments {
+		if fragment == "s3" && i+3 < len(fragments) {
+			return fragments[i+1], true
+		}
+	}
+	return "", false
+}
+
+// Returns the hex encoded SHA-256 hash of the request body, restoring the
+// body so it can still be sent.
+func hashBody(req *http.Request) (string, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return hashHex(nil), nil
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return "", err
+	}
+	req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return hashHex(body), nil
+}
+
+// Builds the canonical URI. S3 paths are encoded once, other services
+// encode the already escaped path a second time.
+func canonicalURI(u *url.URL, service string) string {
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	if service == "s3" {
+		return uriEncode(path, false)
+	}
+	return uriEncode(uriEncode(path, false), false)
+}
+
+// Builds the canonical query string, sorted by key and then value.
+func canonicalQuery(u *url.URL) string {
+	var pairs []string
+	for k, values := range u.Query() {
+		for _, v := range values {
+			pairs = append(pairs, fmt.Sprintf("%s=%s", uriEncode(k, true), uriEncode(v, true)))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// Builds the canonical headers block and the list of signed headers.
+// The host, content and range headers are signed along with any x-amz-* header.
+func canonicalHeaders(req *http.Request) (string, string) {
+	values := map[string]string{}
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	values["host"] = host
+	for name, v := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || lower == "content-md5" || lower == "range" || strings.HasPrefix(lower, "x-amz-") {
+			trimmed := make([]string, len(v))
+			for i, value := range v {
+				trimmed[i] = strings.Join(strings.Fields(value), " ")
+			}
+			values[lower] = strings.Join(trimmed, ",")
+		}
+	}
+
+	var names []string
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var builder strings.Builder
+	for _, name := range names {
+		builder.WriteString(fmt.Sprintf("%s:%s\n", name, values[name]))
+	}
+	return builder.String(), strings.Join(names, ";")
+}
+
+// Percent-encodes every byte except RFC 3986 unreserved characters. Slashes
+// are left intact unless encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+	var builder strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			builder.WriteByte(c)
+			continue
+		}
+		builder.WriteString(fmt.Sprintf("%%%02X", c))
+	}
+	return builder.String()
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
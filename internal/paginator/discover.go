@@ -0,0 +1,55 @@
+package paginator
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/shellhazard/bucketbuster/bucket"
+)
+
+// Discovers the buckets hosted by a service. The service's bucket listing
+// is used if it is public, otherwise each candidate bucket is probed and
+// returned if its first page can be listed.
+func Discover(s bucket.Service) ([]bucket.Bucket, error) {
+	buckets, err := listService(s)
+	if err == nil {
+		return buckets, nil
+	}
+
+	// Fall back to probing candidates
+	for _, b := range s.Candidates() {
+		resp, body, err := fetch(b.PageURL(""), nil)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			continue
+		}
+		if _, _, err := b.ParsePage(body); err != nil {
+			continue
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, nil
+}
+
+// Pages through a service's bucket listing.
+func listService(s bucket.Service) ([]bucket.Bucket, error) {
+	var buckets []bucket.Bucket
+	paginationKey := ""
+	for {
+		resp, body, err := fetch(s.ListURL(paginationKey), nil)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("listing %s returned status %d", s.Name(), resp.StatusCode)
+		}
+		newBuckets, newPaginationKey, err := s.ParseList(body)
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, newBuckets...)
+		if newPaginationKey == "" {
+			return buckets, nil
+		}
+		paginationKey = newPaginationKey
+	}
+}
@@ -0,0 +1,136 @@
+package paginator
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/shellhazard/bucketbuster/bucket"
+)
+
+// Type rewriteTransport sends every request to a test server, keeping the
+// original host in the Host header so handlers can tell hosts apart.
+type rewriteTransport struct {
+	target *url.URL
+}
+
+func (t rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rewritten := req.Clone(req.Context())
+	rewritten.Host = req.URL.Host
+	rewritten.URL.Scheme = t.target.Scheme
+	rewritten.URL.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(rewritten)
+}
+
+// Points the shared client at a test server for the duration of the test.
+func useServer(t *testing.T, srv *httptest.Server) {
+	target, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := Client
+	Client = &http.Client{Transport: rewriteTransport{target: target}}
+	t.Cleanup(func() {
+		Client = original
+	})
+}
+
+func TestDiscoverAzure(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		listingStatus int
+		want          []string
+	}{
+		{
+			name:          "public listing",
+			input:         "https://example.blob.core.windows.net/",
+			listingStatus: http.StatusOK,
+			want:          []string{"example-backups", "example-reports"},
+		},
+		{
+			name:          "listing forbidden",
+			input:         "https://example.blob.core.windows.net/",
+			listingStatus: http.StatusForbidden,
+			want:          []string{"example-$web", "example-public"},
+		},
+		{
+			name:          "listing not found",
+			input:         "https://example.blob.core.windows.net",
+			listingStatus: http.StatusNotFound,
+			want:          []string{"example-$web", "example-public"},
+		},
+		{
+			name:          "SAS token",
+			input:         "https://example.blob.core.windows.net/?sv=2021-08-06&ss=b&srt=sco&sp=rl&se=2099-01-01T00:00:00Z&sig=c2lnbmF0dXJl",
+			listingStatus: http.StatusForbidden,
+			want:          []string{"example-$web", "example-public"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var unsigned []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Host != "example.blob.core.windows.net" {
+					t.Errorf("requested host %q", r.Host)
+				}
+				if strings.Contains(test.input, "sig=") && r.URL.Query().Get("sig") != "c2lnbmF0dXJl" {
+					mu.Lock()
+					unsigned = append(unsigned, r.URL.String())
+					mu.Unlock()
+				}
+				query := r.URL.Query()
+				switch {
+				case r.URL.Path == "/" && query.Get("comp") == "list":
+					w.WriteHeader(test.listingStatus)
+					if test.listingStatus != http.StatusOK {
+						fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><Error><Code>AuthorizationFailure</Code></Error>`)
+						return
+					}
+					fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><EnumerationResults ServiceEndpoint="https://example.blob.core.windows.net/">
+						<Containers><Container><Name>backups</Name></Container><Container><Name>reports</Name></Container></Containers>
+						<NextMarker /></EnumerationResults>`)
+				case (r.URL.Path == "/$web" || r.URL.Path == "/public") && query.Get("restype") == "container":
+					fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Blobs /><NextMarker /></EnumerationResults>`)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+					fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><Error><Code>ContainerNotFound</Code></Error>`)
+				}
+			}))
+			defer srv.Close()
+			useServer(t, srv)
+
+			s, err := bucket.ParseServiceURL(test.input)
+			if err != nil {
+				t.Fatalf("ParseServiceURL failed: %s", err)
+			}
+			if _, ok := s.(bucket.AzureStorageAccount); !ok {
+				t.Fatalf("ParseServiceURL returned %T, want AzureStorageAccount", s)
+			}
+			buckets, err := Discover(s)
+			if err != nil {
+				t.Fatalf("Discover failed: %s", err)
+			}
+			var names []string
+			for _, b := range buckets {
+				names = append(names, b.Name())
+				if strings.Contains(test.input, "sig=") && !strings.Contains(b.PageURL(""), "sig=c2lnbmF0dXJl") {
+					t.Errorf("discovered container %s doesn't carry the SAS token: %s", b.Name(), b.PageURL(""))
+				}
+			}
+			sort.Strings(names)
+			if strings.Join(names, ",") != strings.Join(test.want, ",") {
+				t.Errorf("Discover returned %v, want %v", names, test.want)
+			}
+			if len(unsigned) > 0 {
+				t.Errorf("requests were sent without the SAS token: %v", unsigned)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package paginator
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/shellhazard/bucketbuster/bucket"
+)
+
+// The number of times a listing may be recovered before giving up.
+const maxRecoveries = 2
+
+// The number of consecutive empty pages tolerated before a listing is
+// considered stalled. Some providers return empty pages while skipping
+// over deleted entries.
+const maxEmptyPages = 50
+
+var (
+	ErrRepeatedKey = errors.New("provider returned a pagination key it already returned")
+	ErrEmptyPages  = errors.New("provider returned too many consecutive empty pages")
+	ErrKeyOrder    = errors.New("provider returned keys out of order")
+)
+
+// Type StallError describes a listing that stopped making progress.
+type StallError struct {
+	// The name of the bucket.
+	Bucket string
+
+	// The last key listed before the stall.
+	LastKey string
+
+	// Set if the listing was resumed with a different pagination strategy.
+	Recovered bool
+
+	// The cause of the stall.
+	Err error
+}
+
+func (e *StallError) Error() string {
+	if e.Recovered {
+		return fmt.Sprintf("listing of %s stalled after key %q (%s), resuming with a different pagination strategy", e.Bucket, e.LastKey, e.Err)
+	}
+	return fmt.Sprintf("listing of %s stalled after key %q: %s", e.Bucket, e.LastKey, e.Err)
+}
+
+func (e *StallError) Unwrap() error {
+	return e.Err
+}
+
+// Type Guard detects paginated listings that stop making progress: repeated
+// pagination keys, truncated pages without keys and keys listed out of
+// order. Buckets implementing bucket.RecoverableBucket are resumed with a
+// different pagination strategy, other listings end with a StallError.
+type Guard struct {
+	b          bucket.Bucket
+	seen       map[string]bool
+	lastKey    string
+	emptyPages int
+	recoveries int
+}
+
+func NewGuard(b bucket.Bucket) *Guard {
+	return &Guard{
+		b:    b,
+		seen: map[string]bool{},
+	}
+}
+
+// Checks a page for signs that the listing has stalled and returns the
+// pagination key to continue from. If the listing stalled but was
+// recovered, a StallError with Recovered set is returned with the key.
+// A stalled page is discarded: the listing resumes after the last key of
+// the previous page, so the page's objects are listed again.
+func (g *Guard) Check(objects []bucket.Object, paginationKey string) (string, error) {
+	ordered := true
+	if ub, ok := g.b.(bucket.UnorderedBucket); ok && ub.Unordered() {
+		ordered = false
+	}
+
+	// Only advance past the page once it is accepted
+	lastKey := g.lastKey
+	for _, o := range objects {
+		if ordered && lastKey != "" && o.Key < lastKey {
+			return g.Recover(ErrKeyOrder)
+		}
+		if o.Key > lastKey || !ordered {
+			lastKey = o.Key
+		}
+	}
+
+	if paginationKey == "" {
+		g.lastKey = lastKey
+		return "", nil
+	}
+	if len(objects) == 0 {
+		g.emptyPages++
+		if g.emptyPages > maxEmptyPages {
+			return g.Recover(ErrEmptyPages)
+		}
+	} else {
+		g.emptyPages = 0
+	}
+	if g.seen[paginationKey] {
+		return g.Recover(ErrRepeatedKey)
+	}
+	g.seen[paginationKey] = true
+	g.lastKey = lastKey
+	return paginationKey, nil
+}
+
+// Attempts to recover a stalled listing, returning the key to resume from.
+// Also used directly when a page can't be parsed because of a stall, e.g.
+// bucket.ErrEmptyTruncatedPage.
+func (g *Guard) Recover(cause error) (string, error) {
+	stall := &StallError{
+		Bucket:  g.b.Name(),
+		LastKey: g.lastKey,
+		Err:     cause,
+	}
+	rb, ok := g.b.(bucket.RecoverableBucket)
+	if !ok || g.recoveries >= maxRecoveries {
+		return "", stall
+	}
+	key, ok := rb.Recover(g.lastKey)
+	if !ok {
+		return "", stall
+	}
+	g.recoveries++
+	g.seen = map[string]bool{key: true}
+	g.emptyPages = 0
+	stall.Recovered = true
+	return key, stall
+}
@@ -0,0 +1,256 @@
+package paginator
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/shellhazard/bucketbuster/bucket"
+)
+
+// Type testBucket is a bucket whose listing can't be recovered.
+type testBucket struct {
+	bucket.FirestoreBucket
+}
+
+// Type testRecoverableBucket resumes listings after the last key given.
+type testRecoverableBucket struct {
+	testBucket
+}
+
+func (b testRecoverableBucket) Recover(lastKey string) (string, bool) {
+	return fmt.Sprintf("after:%s", lastKey), true
+}
+
+// Returns objects with the specified keys.
+func objects(keys ...string) []bucket.Object {
+	var objects []bucket.Object
+	for _, key := range keys {
+		objects = append(objects, bucket.Object{Key: key})
+	}
+	return objects
+}
+
+type page struct {
+	objects []bucket.Object
+	token   string
+}
+
+func TestGuard(t *testing.T) {
+	plain := testBucket{bucket.NewFirestoreBucket("example")}
+	recoverable := testRecoverableBucket{plain}
+	unordered := bucket.NewFirestoreBucket("example").WithPrefix("folder/")
+
+	emptyPages := []page{{objects("a"), "t0"}}
+	for i := 1; i <= maxEmptyPages+1; i++ {
+		emptyPages = append(emptyPages, page{nil, fmt.Sprintf("t%d", i)})
+	}
+
+	tests := []struct {
+		name      string
+		b         bucket.Bucket
+		pages     []page
+		wantKey   string
+		wantErr   error
+		recovered bool
+		lastKey   string
+	}{
+		{
+			name:    "progressing",
+			b:       plain,
+			pages:   []page{{objects("a", "b"), "T1"}, {objects("c", "d"), "T2"}, {objects("e"), ""}},
+			wantKey: "",
+		},
+		{
+			name:    "repeated token",
+			b:       plain,
+			pages:   []page{{objects("a", "b"), "T"}, {objects("c", "d"), "T"}},
+			wantErr: ErrRepeatedKey,
+			lastKey: "b",
+		},
+		{
+			name:      "repeated token recovered",
+			b:         recoverable,
+			pages:     []page{{objects("a", "b"), "T"}, {objects("c", "d"), "T"}},
+			wantKey:   "after:b",
+			wantErr:   ErrRepeatedKey,
+			recovered: true,
+			lastKey:   "b",
+		},
+		{
+			name:    "few empty pages",
+			b:       plain,
+			pages:   emptyPages[:maxEmptyPages],
+			wantKey: fmt.Sprintf("t%d", maxEmptyPages-1),
+		},
+		{
+			name:    "run of empty pages",
+			b:       plain,
+			pages:   emptyPages,
+			wantErr: ErrEmptyPages,
+			lastKey: "a",
+		},
+		{
+			name:      "out of order recovered",
+			b:         recoverable,
+			pages:     []page{{objects("a", "b", "c"), "T1"}, {objects("d", "e", "a"), "T2"}},
+			wantKey:   "after:c",
+			wantErr:   ErrKeyOrder,
+			recovered: true,
+			lastKey:   "c",
+		},
+		{
+			name:    "unordered",
+			b:       unordered,
+			pages:   []page{{objects("folder/b/", "folder/a.txt"), "T1"}, {objects("folder/c/", "folder/0.txt"), "T2"}},
+			wantKey: "T2",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g := NewGuard(test.b)
+			var key string
+			var err error
+			for _, p := range test.pages {
+				key, err = g.Check(p.objects, p.token)
+				if err != nil {
+					break
+				}
+			}
+			if key != test.wantKey {
+				t.Errorf("Check returned key %q, want %q", key, test.wantKey)
+			}
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("Check returned error %v, want %v", err, test.wantErr)
+			}
+			if err == nil {
+				return
+			}
+			var stall *StallError
+			if !errors.As(err, &stall) {
+				t.Fatalf("Check returned %T, want a StallError", err)
+			}
+			if stall.Recovered != test.recovered {
+				t.Errorf("StallError.Recovered = %v, want %v", stall.Recovered, test.recovered)
+			}
+			if stall.LastKey != test.lastKey {
+				t.Errorf("StallError.LastKey = %q, want %q", stall.LastKey, test.lastKey)
+			}
+		})
+	}
+}
+
+func TestGuardFirestoreFlat(t *testing.T) {
+	b := bucket.NewFirestoreBucket("example.appspot.com")
+	g := NewGuard(b)
+	pages := []string{
+		`{"prefixes":["images/","videos/"],"items":[{"name":"a.txt"}],"nextPageToken":"T1"}`,
+		`{"prefixes":["zips/"],"items":[{"name":"b.txt"}]}`,
+	}
+	for _, data := range pages {
+		objects, token, err := b.ParsePage([]byte(data))
+		if err != nil {
+			t.Fatalf("ParsePage failed: %s", err)
+		}
+		if _, err := g.Check(objects, token); err != nil {
+			t.Fatalf("Check returned error %v", err)
+		}
+	}
+}
+
+func TestGuardRecoversS3(t *testing.T) {
+	b := bucket.NewS3Bucket("https://guard.s3.amazonaws.com", "guard")
+	g := NewGuard(b)
+
+	// A ListObjectsV2 page sets the dialect and continuation token
+	_, token, err := b.ParsePage([]byte(`<ListBucketResult><KeyCount>2</KeyCount><IsTruncated>true</IsTruncated>
+		<NextContinuationToken>T</NextContinuationToken>
+		<Contents><Key>a</Key></Contents><Contents><Key>b</Key></Contents></ListBucketResult>`))
+	if err != nil {
+		t.Fatalf("ParsePage failed: %s", err)
+	}
+	if _, err := g.Check(objects("a", "b"), token); err != nil {
+		t.Fatalf("Check failed: %s", err)
+	}
+
+	// Each stall moves on to the next strategy, resuming after the last key.
+	// The stalled pages repeat the key most recently returned.
+	wantURLs := []string{
+		"https://guard.s3.amazonaws.com?list-type=2&encoding-type=url&start-after=b",
+		"https://guard.s3.amazonaws.com?encoding-type=url&marker=b",
+	}
+	for _, want := range wantURLs {
+		key, err := g.Check(objects("c"), token)
+		token = key
+		var stall *StallError
+		if !errors.As(err, &stall) || !stall.Recovered {
+			t.Fatalf("Check returned %v, want a recovered StallError", err)
+		}
+		if got := b.PageURL(key); got != want {
+			t.Errorf("PageURL(%q) = %q, want %q", key, got, want)
+		}
+	}
+
+	// No strategies remain
+	_, err = g.Check(objects("c"), token)
+	var stall *StallError
+	if !errors.As(err, &stall) || stall.Recovered {
+		t.Fatalf("Check returned %v, want an unrecovered StallError", err)
+	}
+}
+
+func TestGuardRecoversWithMarker(t *testing.T) {
+	tests := []struct {
+		b    bucket.Bucket
+		want string
+	}{
+		{bucket.NewGoogleStorageBucket("example"), "https://example.storage.googleapis.com/?encoding-type=url&marker=b+c"},
+		{bucket.NewOSSBucket("example", "oss-cn-hangzhou.aliyuncs.com"), "https://example.oss-cn-hangzhou.aliyuncs.com/?encoding-type=url&marker=b+c"},
+	}
+	for _, test := range tests {
+		g := NewGuard(test.b)
+		if _, err := g.Check(objects("a", "b c"), "next"); err != nil {
+			t.Fatalf("Check failed: %s", err)
+		}
+		key, err := g.Check(objects("d"), "next")
+		var stall *StallError
+		if !errors.As(err, &stall) || !stall.Recovered {
+			t.Fatalf("Check of %s returned %v, want a recovered StallError", test.b.Name(), err)
+		}
+		if got := test.b.PageURL(key); got != test.want {
+			t.Errorf("PageURL(%q) = %q, want %q", key, got, test.want)
+		}
+	}
+
+	// Resuming after a key would skip its other generations
+	versions := bucket.NewGoogleStorageBucket("example").WithVersions()
+	if _, ok := versions.(bucket.RecoverableBucket).Recover("a"); ok {
+		t.Error("Recover resumed a listing of every generation")
+	}
+}
+
+func TestGuardStopsUnrecoverable(t *testing.T) {
+	// These providers only accept their own continuation tokens
+	for _, b := range []bucket.Bucket{
+		bucket.NewFirestoreBucket("example"),
+		bucket.NewGoogleStorageJSONBucket("example"),
+		bucket.NewAzureStorageBucket("example", "files"),
+		bucket.NewAzureDataLakeBucket("example", "files"),
+		bucket.NewSwiftBucket("https://swift.example.com/v1/AUTH_abc/files", "files"),
+		bucket.NewOCIBucket("objectstorage.us-ashburn-1.oraclecloud.com", "tenancy", "files", ""),
+	} {
+		if _, ok := b.(bucket.RecoverableBucket); ok {
+			t.Errorf("%T unexpectedly implements RecoverableBucket", b)
+			continue
+		}
+		g := NewGuard(b)
+		if _, err := g.Check(objects("a"), "next"); err != nil {
+			t.Fatalf("Check failed: %s", err)
+		}
+		_, err := g.Check(objects("b"), "next")
+		var stall *StallError
+		if !errors.As(err, &stall) || stall.Recovered || !errors.Is(err, ErrRepeatedKey) {
+			t.Errorf("Check of %T returned %v, want an unrecovered StallError", b, err)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package paginator
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/shellhazard/bucketbuster/bucket"
+)
+
+// Fetches the metadata of each object, using up to concurrency requests at
+// once, and returns the objects with their metadata merged in. Objects whose
+// metadata can't be fetched are returned unchanged, with an error for each.
+func FetchMetadata(b bucket.MetadataBucket, objects []bucket.Object, concurrency int) ([]bucket.Object, []error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	results := make([]bucket.Object, len(objects))
+	errs := make([]error, len(objects))
+
+	var wg sync.WaitGroup
+	sem := make(chan bool, concurrency)
+	for i, o := range objects {
+		results[i] = o
+		// Directories have no metadata document
+		if o.Directory {
+			continue
+		}
+		wg.Add(1)
+		sem <- true
+		go func(i int, o bucket.Object) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			results[i], errs[i] = fetchMetadata(b, o)
+		}(i, o)
+	}
+	wg.Wait()
+
+	var failed []error
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	return results, failed
+}
+
+// Fetches the metadata of a single object.
+func fetchMetadata(b bucket.MetadataBucket, o bucket.Object) (bucket.Object, error) {
+	resp, body, err := fetch(b.MetadataURL(o.Key), nil)
+	if err != nil {
+		return o, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return o, fmt.Errorf("fetching metadata of %s returned status %d", o.Key, resp.StatusCode)
+	}
+	return b.ParseMetadata(body, o)
+}
@@ -1,39 +1,102 @@
 package paginator
 
 import (
+	"bytes"
+	"fmt"
 	"io"
 	"net/http"
 
 	"github.com/shellhazard/bucketbuster/bucket"
 )
 
+// The HTTP client used for every request. Its transport can be replaced to
+// authenticate requests.
+var Client = &http.Client{}
+
+// Type RedirectError is returned by Paginate when the provider redirects a
+// listing to another endpoint, such as the bucket's region.
+type RedirectError struct {
+	// The bucket rebuilt for the endpoint redirected to.
+	Bucket bucket.Bucket
+
+	// The status code of the redirect.
+	StatusCode int
+}
+
+func (e *RedirectError) Error() string {
+	return fmt.Sprintf("listing redirected with status %d to %s", e.StatusCode, e.Bucket.URL())
+}
+
 // Paginates the target bucket, fetching a page and returning a list
-// of keys as well as the next pagination key if applicable.
-func Paginate(b bucket.Bucket, paginationKey string) ([]string, string, error) {
-	var keys []string
+// of objects as well as the next pagination key if applicable.
+func Paginate(b bucket.Bucket, paginationKey string) ([]bucket.Object, string, error) {
+	var objects []bucket.Object
 	var newPaginationKey string
 	var targetURL string
 
 	// Use the provided pagination key if not empty.
 	targetURL = b.PageURL(paginationKey)
 
-	// Fetch the target page.
-	resp, err := http.Get(targetURL)
+	// Fetch the target page, with any headers the bucket requires.
+	var header map[string]string
+	if hb, ok := b.(bucket.RequestHeaderBucket); ok {
+		header = hb.RequestHeaders()
+	}
+	resp, body, err := fetch(targetURL, header)
 	if err != nil {
 		return nil, "", err
 	}
-	defer resp.Body.Close()
 
-	// Read entire body into memory.
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, "", err
+	// Rebuild the bucket if the provider redirected the request.
+	if rb, ok := b.(bucket.RedirectBucket); ok && resp.StatusCode != http.StatusOK {
+		if redirected, ok := rb.Redirect(resp.StatusCode, resp.Header, body); ok {
+			return nil, "", &RedirectError{Bucket: redirected, StatusCode: resp.StatusCode}
+		}
 	}
 
 	// Parse the page.
-	keys, newPaginationKey, err = b.ParsePage(body)
+	objects, newPaginationKey, err = b.ParsePage(body)
 	if err != nil {
 		return nil, "", err
 	}
-	return keys, newPaginationKey, nil
+
+	// Some providers return the next pagination key in a header.
+	if hb, ok := b.(bucket.HeaderPaginatedBucket); ok && newPaginationKey == "" {
+		newPaginationKey = resp.Header.Get(hb.PaginationHeader())
+	}
+	return objects, newPaginationKey, nil
+}
+
+// Fetches the target URL with the specified headers, returning the
+// response and its body. The response body has already been read and closed.
+func fetch(targetURL string, header map[string]string) (*http.Response, []byte, error) {
+	return Send(Client, http.MethodGet, targetURL, header, nil)
+}
+
+// Sends a request with the specified method, headers and content, which may
+// be nil, returning the response and its body. The response body has
+// already been read and closed.
+func Send(client *http.Client, method string, targetURL string, header map[string]string, content []byte) (*http.Response, []byte, error) {
+	var reqBody io.Reader
+	if content != nil {
+		reqBody = bytes.NewReader(content)
+	}
+	req, err := http.NewRequest(method, targetURL, reqBody)
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, body, nil
 }
@@ -0,0 +1,62 @@
+package paginator
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/shellhazard/bucketbuster/bucket"
+)
+
+func TestPaginateHeader(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != "example.dfs.core.windows.net" || r.URL.Path != "/files" {
+			t.Errorf("requested %s%s", r.Host, r.URL.Path)
+		}
+		if got := r.Header.Get("x-ms-version"); got == "" {
+			t.Error("request didn't set x-ms-version")
+		}
+		switch r.URL.Query().Get("continuation") {
+		case "":
+			w.Header().Set("x-ms-continuation", "VBaS6LvPp+Y=")
+			fmt.Fprint(w, `{"paths":[{"name":"docs","isDirectory":"true","lastModified":"Mon, 01 Jan 2024 00:00:00 GMT"},
+				{"name":"docs/a.txt","contentLength":"1024","etag":"0x8DB"}]}`)
+		case "VBaS6LvPp+Y=":
+			fmt.Fprint(w, `{"paths":[{"name":"readme.md","isDirectory":"false","contentLength":"7"}]}`)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+	useServer(t, srv)
+
+	b := bucket.NewAzureDataLakeBucket("example", "files")
+	objects, token, err := Paginate(b, "")
+	if err != nil {
+		t.Fatalf("Paginate failed: %s", err)
+	}
+	if token != "VBaS6LvPp+Y=" {
+		t.Fatalf("Paginate returned token %q, want the x-ms-continuation header", token)
+	}
+	want := []bucket.Object{
+		{Key: "docs", Directory: true, LastModified: "Mon, 01 Jan 2024 00:00:00 GMT"},
+		{Key: "docs/a.txt", Size: 1024, ETag: "0x8DB"},
+	}
+	if !reflect.DeepEqual(objects, want) {
+		t.Errorf("Paginate returned %+v, want %+v", objects, want)
+	}
+
+	objects, token, err = Paginate(b, token)
+	if err != nil {
+		t.Fatalf("Paginate failed: %s", err)
+	}
+	if token != "" {
+		t.Errorf("Paginate returned token %q on the last page", token)
+	}
+	want = []bucket.Object{{Key: "readme.md", Size: 7}}
+	if !reflect.DeepEqual(objects, want) {
+		t.Errorf("Paginate returned %+v, want %+v", objects, want)
+	}
+}
@@ -0,0 +1,96 @@
+package paginator
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/shellhazard/bucketbuster/bucket"
+)
+
+// Walks the folder hierarchy of a bucket breadth-first, listing up to
+// concurrency folders at once. Each page of objects, including the folders
+// themselves as directories, is passed to emit, which is never called
+// concurrently. Folders that can't be listed are skipped and an error is
+// returned for each.
+func Traverse(b bucket.PrefixBucket, concurrency int, emit func([]bucket.Object)) []error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var mu sync.Mutex
+	var errs []error
+	seen := map[string]bool{"": true}
+	level := []string{""}
+
+	for len(level) > 0 {
+		var next []string
+		var wg sync.WaitGroup
+		sem := make(chan bool, concurrency)
+		for _, prefix := range level {
+			wg.Add(1)
+			sem <- true
+			go func(prefix string) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				err := listPrefix(b.WithPrefix(prefix), func(objects []bucket.Object) {
+					mu.Lock()
+					defer mu.Unlock()
+					for _, o := range objects {
+						if !o.Directory {
+							continue
+						}
+						folder := o.Key
+						if !strings.HasSuffix(folder, "/") {
+							folder += "/"
+						}
+						// Guard against providers returning a folder as its own child
+						if !seen[folder] {
+							seen[folder] = true
+							next = append(next, folder)
+						}
+					}
+					emit(objects)
+				})
+				if err != nil {
+					mu.Lock()
+					errs = append(errs, fmt.Errorf("listing folder %q: %w", prefix, err))
+					mu.Unlock()
+				}
+			}(prefix)
+		}
+		wg.Wait()
+		level = next
+	}
+	return errs
+}
+
+// Pages through a single folder, passing each page to emit.
+func listPrefix(b bucket.Bucket, emit func([]bucket.Object)) error {
+	guard := NewGuard(b)
+	paginationKey := ""
+	for {
+		objects, newPaginationKey, err := Paginate(b, paginationKey)
+		if errors.Is(err, bucket.ErrEmptyTruncatedPage) {
+			objects = nil
+			newPaginationKey, err = guard.Recover(err)
+		} else if err == nil {
+			newPaginationKey, err = guard.Check(objects, newPaginationKey)
+		}
+		var stall *StallError
+		if errors.As(err, &stall) && stall.Recovered {
+			paginationKey = newPaginationKey
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		emit(objects)
+		if newPaginationKey == "" {
+			return nil
+		}
+		paginationKey = newPaginationKey
+	}
+}
@@ -0,0 +1,141 @@
+package paginator
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shellhazard/bucketbuster/bucket"
+)
+
+// Type treeBucket lists a fake folder hierarchy served by a test server,
+// using the Firebase Storage listing format.
+type treeBucket struct {
+	bucket.FirestoreBucket
+	baseURL string
+	prefix  string
+}
+
+func (b treeBucket) WithPrefix(prefix string) bucket.Bucket {
+	b.FirestoreBucket = b.FirestoreBucket.WithPrefix(prefix).(bucket.FirestoreBucket)
+	b.prefix = prefix
+	return b
+}
+
+func (b treeBucket) Unordered() bool {
+	return true
+}
+
+func (b treeBucket) PageURL(paginationKey string) string {
+	return fmt.Sprintf("%s/?prefix=%s&pageToken=%s", b.baseURL, url.QueryEscape(b.prefix), url.QueryEscape(paginationKey))
+}
+
+func TestTraverse(t *testing.T) {
+	// Pages of each folder, keyed by prefix and then page token
+	tree := map[string]map[string]string{
+		"": {"": `{"prefixes":["a/","b/","c/","d/"],"items":[{"name":"root.txt"}]}`},
+		"a/": {
+			"":   `{"prefixes":["a/x/"],"items":[{"name":"a/1.txt"}],"nextPageToken":"p2"}`,
+			"p2": `{"items":[{"name":"a/2.txt"}]}`,
+		},
+		// Folders listed as their own child are only walked once
+		"b/":   {"": `{"prefixes":["b/","b/y/"],"items":[{"name":"b/1.txt"}]}`},
+		"c/":   {"": `{"items":[{"name":"c/1.txt"}]}`},
+		"d/":   {"": `{"items":[{"name":"d/1.txt"}]}`},
+		"a/x/": {"": `{"items":[{"name":"a/x/1.txt"}]}`},
+		"b/y/": {"": `{"prefixes":["b/y/z/"]}`},
+		// Folders that can't be listed are skipped
+		"b/y/z/": {},
+	}
+	// Returns the depth of the folder an object was listed in, assuming
+	// folders aren't listed as their own child
+	depth := func(o bucket.Object) int {
+		if o.Directory {
+			return strings.Count(o.Key, "/") - 1
+		}
+		return strings.Count(o.Key, "/")
+	}
+
+	var inFlight, maxInFlight int64
+	var mu sync.Mutex
+	requests := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&inFlight, 1)
+		defer atomic.AddInt64(&inFlight, -1)
+		for {
+			max := atomic.LoadInt64(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt64(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+
+		prefix, token := r.URL.Query().Get("prefix"), r.URL.Query().Get("pageToken")
+		mu.Lock()
+		requests[prefix]++
+		mu.Unlock()
+		page, ok := tree[prefix][token]
+		if !ok {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, "Permission denied.")
+			return
+		}
+		fmt.Fprint(w, page)
+	}))
+	defer srv.Close()
+
+	var emitting int32
+	var keys, pages []string
+	var depths []int
+	errs := Traverse(treeBucket{baseURL: srv.URL}, 2, func(objects []bucket.Object) {
+		if !atomic.CompareAndSwapInt32(&emitting, 0, 1) {
+			t.Error("emit was called concurrently")
+		}
+		defer atomic.StoreInt32(&emitting, 0)
+		time.Sleep(5 * time.Millisecond)
+		level := 0
+		for _, o := range objects {
+			keys = append(keys, o.Key)
+			if depth(o) > level {
+				level = depth(o)
+			}
+		}
+		pages = append(pages, objects[0].Key)
+		depths = append(depths, level)
+	})
+
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), `"b/y/z/"`) {
+		t.Errorf("Traverse returned errors %v, want one for b/y/z/", errs)
+	}
+	if maxInFlight > 2 {
+		t.Errorf("Traverse made %d requests at once, want at most 2", maxInFlight)
+	}
+	if maxInFlight < 2 {
+		t.Errorf("Traverse made at most %d request at once, want folders listed concurrently", maxInFlight)
+	}
+	for prefix, n := range requests {
+		if want := len(tree[prefix]); n != want && !(want == 0 && n == 1) {
+			t.Errorf("Traverse requested %q %d times, want %d", prefix, n, want)
+		}
+	}
+
+	// Breadth-first: every folder of a level is listed before the next level
+	for i := 1; i < len(depths); i++ {
+		if depths[i] < depths[i-1] {
+			t.Errorf("Traverse emitted the page starting %q after %q, want breadth-first order", pages[i], pages[i-1])
+		}
+	}
+
+	sort.Strings(keys)
+	want := []string{"a/", "a/1.txt", "a/2.txt", "a/x/", "a/x/1.txt", "b/", "b/", "b/1.txt", "b/y/", "b/y/z/", "c/", "c/1.txt", "d/", "d/1.txt", "root.txt"}
+	if strings.Join(keys, ",") != strings.Join(want, ",") {
+		t.Errorf("Traverse emitted %v, want %v", keys, want)
+	}
+}
@@ -0,0 +1,262 @@
+package takeover
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// DNS record types, classes and response codes used by CNAME queries.
+const (
+	dnsTypeCNAME     = 5
+	dnsClassINET     = 1
+	dnsRcodeNXDomain = 3
+)
+
+// The file listing the system's nameservers.
+var resolvConf = "/etc/resolv.conf"
+
+// Type DNSResolver reads CNAME records directly from a nameserver.
+// net.Resolver.LookupCNAME follows the chain to an address and fails if
+// the target doesn't resolve, hiding the CNAME of a deleted account.
+type DNSResolver struct {
+	// The nameserver to query, as host:port. Defaults to the first
+	// nameserver in /etc/resolv.conf, and lookups fail if there is none.
+	Server string
+
+	// The time allowed for each query. Defaults to 5 seconds.
+	Timeout time.Duration
+}
+
+// Returns the target of the host's CNAME record, or an empty string if it
+// has none. The target doesn't need to resolve. Truncated responses are
+// retried over TCP.
+func (r DNSResolver) LookupCNAME(ctx context.Context, host string) (string, error) {
+	server := r.Server
+	if server == "" {
+		var err error
+		server, err = systemDNSServer()
+		if err != nil {
+			return "", &net.DNSError{Err: err.Error(), Name: host}
+		}
+	}
+	timeout := r.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+
+	query, id, err := dnsQuery(host, dnsTypeCNAME)
+	if err != nil {
+		return "", err
+	}
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	msg, err := dnsExchange(ctx, "udp", server, query, id, deadline)
+	if err == nil && len(msg) >= 12 && msg[2]&0x02 != 0 {
+		msg, err = dnsExchange(ctx, "tcp", server, query, id, deadline)
+	}
+	if err != nil {
+		return "", &net.DNSError{Err: err.Error(), Name: host, Server: server, IsTimeout: errors.Is(err, os.ErrDeadlineExceeded)}
+	}
+	return parseCNAME(msg, host, server)
+}
+
+// Sends a query to the server over UDP or TCP and returns the response
+// with the query's ID.
+func dnsExchange(ctx context.Context, network string, server string, query []byte, id uint16, deadline time.Time) ([]byte, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(deadline)
+
+	if network == "tcp" {
+		// TCP messages are prefixed with their length
+		if _, err := conn.Write(append([]byte{byte(len(query) >> 8), byte(len(query))}, query...)); err != nil {
+			return nil, err
+		}
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return nil, err
+		}
+		msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, msg); err != nil {
+			return nil, err
+		}
+		if len(msg) < 12 || binary.BigEndian.Uint16(msg) != id {
+			return nil, errors.New("DNS response doesn't match the query")
+		}
+		return msg, nil
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// Ignore stray responses to other queries
+		if n < 12 || binary.BigEndian.Uint16(buf) != id {
+			continue
+		}
+		return buf[:n], nil
+	}
+}
+
+// Returns the host's addresses using the system resolver.
+func (r DNSResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	return net.DefaultResolver.LookupHost(ctx, host)
+}
+
+// Builds a recursive query for a record of the host and returns it with
+// its ID.
+func dnsQuery(host string, recordType uint16) ([]byte, uint16, error) {
+	var idBytes [2]byte
+	if _, err := rand.Read(idBytes[:]); err != nil {
+		return nil, 0, err
+	}
+	id := binary.BigEndian.Uint16(idBytes[:])
+
+	// Header: ID, recursion desired, one question
+	msg := []byte{idBytes[0], idBytes[1], 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, 0, fmt.Errorf("invalid hostname %q", host)
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0)
+	msg = append(msg, byte(recordType>>8), byte(recordType), 0, dnsClassINET)
+	return msg, id, nil
+}
+
+// Parses a response to a CNAME query and returns the target of the host's
+// CNAME record, or an empty string if it has none. NXDOMAIN responses
+// without a CNAME record are returned as not found errors, and messages
+// that aren't responses or were truncated are rejected.
+func parseCNAME(msg []byte, host string, server string) (string, error) {
+	malformed := &net.DNSError{Err: "malformed DNS response", Name: host, Server: server}
+	if len(msg) < 12 {
+		return "", malformed
+	}
+	if msg[2]&0x80 == 0 {
+		return "", &net.DNSError{Err: "DNS message is not a response", Name: host, Server: server}
+	}
+	if msg[2]&0x02 != 0 {
+		return "", &net.DNSError{Err: "DNS response was truncated", Name: host, Server: server}
+	}
+	rcode := msg[3] & 0x0f
+	questions := int(binary.BigEndian.Uint16(msg[4:]))
+	answers := int(binary.BigEndian.Uint16(msg[6:]))
+
+	offset := 12
+	for i := 0; i < questions; i++ {
+		_, next, err := readName(msg, offset)
+		if err != nil || next+4 > len(msg) {
+			return "", malformed
+		}
+		offset = next + 4
+	}
+	for i := 0; i < answers; i++ {
+		name, next, err := readName(msg, offset)
+		if err != nil || next+10 > len(msg) {
+			return "", malformed
+		}
+		recordType := binary.BigEndian.Uint16(msg[next:])
+		length := int(binary.BigEndian.Uint16(msg[next+8:]))
+		data := next + 10
+		if data+length > len(msg) {
+			return "", malformed
+		}
+		if recordType == dnsTypeCNAME && strings.EqualFold(name, strings.TrimSuffix(host, ".")) {
+			target, _, err := readName(msg, data)
+			if err != nil {
+				return "", malformed
+			}
+			return target, nil
+		}
+		offset = data + length
+	}
+
+	switch rcode {
+	case 0:
+		return "", nil
+	case dnsRcodeNXDomain:
+		return "", &net.DNSError{Err: "no such host", Name: host, Server: server, IsNotFound: true}
+	default:
+		return "", &net.DNSError{Err: fmt.Sprintf("server returned response code %d", rcode), Name: host, Server: server}
+	}
+}
+
+// Reads a possibly compressed domain name at offset, returning it without
+// a trailing dot along with the offset following it.
+func readName(msg []byte, offset int) (string, int, error) {
+	var labels []string
+	next := -1
+	for jumps := 0; ; {
+		if offset >= len(msg) {
+			return "", 0, errors.New("name out of range")
+		}
+		length := int(msg[offset])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = offset + 1
+			}
+			return strings.Join(labels, "."), next, nil
+		case length&0xc0 == 0xc0:
+			// Compression pointer to an earlier name
+			if offset+1 >= len(msg) || jumps > 16 {
+				return "", 0, errors.New("invalid name pointer")
+			}
+			if next < 0 {
+				next = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(msg[offset:]) & 0x3fff)
+			jumps++
+		default:
+			if offset+1+length > len(msg) {
+				return "", 0, errors.New("label out of range")
+			}
+			labels = append(labels, string(msg[offset+1:offset+1+length]))
+			offset += 1 + length
+		}
+	}
+}
+
+// Returns the first nameserver in /etc/resolv.conf, or an error if none
+// is configured.
+func systemDNSServer() (string, error) {
+	file, err := os.Open(resolvConf)
+	if err != nil {
+		return "", fmt.Errorf("no nameserver configured: %w", err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return net.JoinHostPort(fields[1], "53"), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("reading %s: %w", resolvConf, err)
+	}
+	return "", fmt.Errorf("no nameserver configured in %s", resolvConf)
+}
@@ -0,0 +1,292 @@
+package takeover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Interface Resolver looks up DNS records. DNSResolver satisfies it, and
+// tests can substitute a fake to run offline.
+type Resolver interface {
+	// Returns the target of the host's CNAME record, or an empty string if
+	// it has none. The target is returned even if it doesn't resolve.
+	LookupCNAME(ctx context.Context, host string) (string, error)
+
+	// Returns the host's addresses.
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// The maximum number of CNAME records followed from a hostname.
+const maxCNAMEs = 8
+
+// Type Provider describes how a storage provider reports a missing bucket
+// behind a hostname.
+type Provider struct {
+	// The name of the provider.
+	Name string
+
+	// Hostname suffixes of the provider's endpoints.
+	Suffixes []string
+
+	// Error codes returned when the bucket doesn't exist, if the provider
+	// responds for missing buckets.
+	Codes []string
+
+	// Set if the bucket is missing when the endpoint itself doesn't
+	// resolve, as with deleted Azure storage accounts.
+	Unresolvable bool
+
+	// Returns the bucket name to claim, and whether claiming it is possible
+	// without proving ownership of the domain.
+	BucketName func(host string, cname string) (string, bool)
+
+	// A note on claiming the bucket.
+	Note string
+}
+
+// Providers checked for dangling buckets.
+var Providers = []Provider{
+	{
+		Name:     "Amazon S3",
+		Suffixes: []string{".amazonaws.com"},
+		Codes:    []string{"NoSuchBucket"},
+		BucketName: func(host string, cname string) (string, bool) {
+			// S3 routes requests by Host header, so the bucket is named after the custom domain
+			if host != cname {
+				return host, true
+			}
+			return labelsBefore(host, "s3"), true
+		},
+		Note: "Create an S3 bucket with this name in the region the CNAME points to.",
+	},
+	{
+		Name:     "Google Cloud Storage",
+		Suffixes: []string{".storage.googleapis.com"},
+		Codes:    []string{"NoSuchBucket"},
+		BucketName: func(host string, cname string) (string, bool) {
+			// Buckets named after a domain require proving ownership of it
+			if host != cname {
+				return host, false
+			}
+			return strings.TrimSuffix(host, ".storage.googleapis.com"), true
+		},
+		Note: "GCS buckets named after a domain can only be created by a verified owner of that domain.",
+	},
+	{
+		Name:     "Alibaba Cloud OSS",
+		Suffixes: []string{".aliyuncs.com"},
+		Codes:    []string{"NoSuchBucket"},
+		BucketName: func(host string, cname string) (string, bool) {
+			return labelsBefore(cname, "oss"), true
+		},
+		Note: "Create an OSS bucket with this name in the region the CNAME points to and bind the domain to it.",
+	},
+	{
+		Name:         "Azure Blob Storage",
+		Suffixes:     []string{".blob.core.windows.net", ".web.core.windows.net"},
+		Unresolvable: true,
+		BucketName: func(host string, cname string) (string, bool) {
+			return strings.Split(cname, ".")[0], true
+		},
+		Note: "Create a storage account with this name.",
+	},
+}
+
+// Type Finding is the result of checking a hostname for a dangling bucket.
+type Finding struct {
+	// The input URL or hostname.
+	Input string `json:"input"`
+
+	// The hostname checked.
+	Host string `json:"host"`
+
+	// The canonical name the hostname resolves to, if it is an alias.
+	CNAME string `json:"cname,omitempty"`
+
+	// The storage provider the hostname points to, if recognised.
+	Provider string `json:"provider,omitempty"`
+
+	// The HTTP status code returned, or 0 if the request failed.
+	Status int `json:"status,omitempty"`
+
+	// The provider error code indicating the bucket is missing.
+	Code string `json:"code,omitempty"`
+
+	// Set if the hostname points to a bucket or account that doesn't exist.
+	Dangling bool `json:"dangling"`
+
+	// Set if the missing bucket could be created by anyone.
+	Claimable bool `json:"claimable"`
+
+	// The name of the bucket or account to create to claim the hostname.
+	BucketName string `json:"bucketName,omitempty"`
+
+	// A note on claiming the bucket.
+	Note string `json:"note,omitempty"`
+
+	// The reason the check failed, if it did.
+	Error string `json:"error,omitempty"`
+}
+
+// Type Checker checks hostnames for dangling storage buckets.
+type Checker struct {
+	// Resolves DNS records. Defaults to a DNSResolver.
+	Resolver Resolver
+
+	// Sends requests to the hostname. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Matches error codes in XML error documents and S3 website error pages.
+var codePattern = regexp.MustCompile(`<Code>([^<]+)</Code>|Code: ([A-Za-z]+)`)
+
+// Checks whether the input URL or hostname points to a storage provider
+// but the bucket behind it doesn't exist.
+func (c Checker) Check(ctx context.Context, input string) Finding {
+	finding := Finding{Input: input}
+	host, err := hostname(input)
+	if err != nil {
+		finding.Error = err.Error()
+		return finding
+	}
+	finding.Host = host
+
+	resolver := c.Resolver
+	if resolver == nil {
+		resolver = DNSResolver{}
+	}
+
+	// Follow the CNAME chain to the first provider endpoint, which may be
+	// an alias for the provider's own hosts
+	cname := host
+	provider, ok := matchProvider(host)
+	for i := 0; i < maxCNAMEs && !ok; i++ {
+		target, err := resolver.LookupCNAME(ctx, cname)
+		if err != nil && !isNotFound(err) {
+			finding.Error = fmt.Sprintf("CNAME lookup failed: %s", err)
+			return finding
+		}
+		target = strings.ToLower(strings.TrimSuffix(target, "."))
+		if target == "" {
+			break
+		}
+		cname = target
+		provider, ok = matchProvider(cname)
+	}
+	if cname != host {
+		finding.CNAME = cname
+	}
+	if !ok {
+		return finding
+	}
+	finding.Provider = provider.Name
+
+	// A deleted account leaves its endpoint unresolvable
+	if provider.Unresolvable {
+		_, err := resolver.LookupHost(ctx, cname)
+		if isNotFound(err) {
+			finding.flag(provider, host, cname)
+			return finding
+		}
+	}
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	// Requested over HTTP, since S3 website endpoints don't serve HTTPS and
+	// provider certificates don't cover custom domains
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://%s/", host), nil)
+	if err != nil {
+		finding.Error = err.Error()
+		return finding
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		finding.Error = err.Error()
+		return finding
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	finding.Status = resp.StatusCode
+
+	code := errorCode(body)
+	for _, missing := range provider.Codes {
+		if code == missing {
+			finding.Code = code
+			finding.flag(provider, host, cname)
+		}
+	}
+	return finding
+}
+
+// Marks the finding as a dangling bucket of the provider.
+func (f *Finding) flag(provider Provider, host string, cname string) {
+	f.Dangling = true
+	f.BucketName, f.Claimable = provider.BucketName(host, cname)
+	f.Note = provider.Note
+}
+
+// Returns the provider whose endpoints the hostname belongs to.
+func matchProvider(host string) (Provider, bool) {
+	for _, provider := range Providers {
+		for _, suffix := range provider.Suffixes {
+			if strings.HasSuffix(host, suffix) {
+				return provider, true
+			}
+		}
+	}
+	return Provider{}, false
+}
+
+// Returns the provider error code in a response body, if any.
+func errorCode(body []byte) string {
+	match := codePattern.FindSubmatch(body)
+	if match == nil {
+		return ""
+	}
+	if len(match[1]) > 0 {
+		return string(match[1])
+	}
+	return string(match[2])
+}
+
+// Returns the lowercase hostname of an input URL or bare hostname.
+func hostname(input string) (string, error) {
+	if !strings.Contains(input, "://") {
+		input = fmt.Sprintf("https://%s", input)
+	}
+	urlData, err := url.Parse(input)
+	if err != nil {
+		return "", err
+	}
+	if urlData.Hostname() == "" {
+		return "", errors.New("Invalid URL (missing host)")
+	}
+	return strings.ToLower(urlData.Hostname()), nil
+}
+
+// Returns the labels of a hostname before the first label starting with
+// prefix, e.g. the bucket in example.s3.eu-west-1.amazonaws.com.
+func labelsBefore(host string, prefix string) string {
+	labels := strings.Split(host, ".")
+	for i, label := range labels {
+		if i > 0 && strings.HasPrefix(label, prefix) {
+			return strings.Join(labels[:i], ".")
+		}
+	}
+	return host
+}
+
+// Returns true if the error is a DNS lookup that found no records.
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
@@ -0,0 +1,333 @@
+package takeover
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Type testResolver answers like a recursive resolver for a fixed set of
+// records. CNAME lookups return the record itself, while host lookups
+// follow CNAMEs and fail if the final target has no addresses.
+type testResolver struct {
+	cnames map[string]string
+	hosts  map[string][]string
+}
+
+func (r testResolver) LookupCNAME(ctx context.Context, host string) (string, error) {
+	if cname, ok := r.cnames[host]; ok {
+		return cname + ".", nil
+	}
+	if _, ok := r.hosts[host]; ok {
+		return "", nil
+	}
+	return "", &net.DNSError{Name: host, Err: "no such host", IsNotFound: true}
+}
+
+func (r testResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	for i := 0; i < maxCNAMEs; i++ {
+		cname, ok := r.cnames[host]
+		if !ok {
+			break
+		}
+		host = cname
+	}
+	if addrs, ok := r.hosts[host]; ok {
+		return addrs, nil
+	}
+	return nil, &net.DNSError{Name: host, Err: "no such host", IsNotFound: true}
+}
+
+func TestCheck(t *testing.T) {
+	// Providers answer for custom domains over plain HTTP
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Host {
+		case "assets.example.com", "static.example.com":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchBucket</Code><Message>The specified bucket does not exist</Message></Error>`)
+		case "website.example.com":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `<html><body><h1>404 Not Found</h1><ul><li>Code: NoSuchBucket</li></ul></body></html>`)
+		case "live.example.com":
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `<Error><Code>AccessDenied</Code></Error>`)
+		default:
+			fmt.Fprint(w, "ok")
+		}
+	}))
+	defer srv.Close()
+
+	// Send every request to the test server, as DNS would for each alias
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+			return net.Dial(network, srv.Listener.Addr().String())
+		},
+	}}
+	checker := Checker{
+		Resolver: testResolver{
+			cnames: map[string]string{
+				"assets.example.com":                                     "assets.example.com.s3.amazonaws.com",
+				"assets.example.com.s3.amazonaws.com":                    "s3-1-w.amazonaws.com",
+				"website.example.com":                                    "website.example.com.s3-website.eu-west-1.amazonaws.com",
+				"static.example.com":                                     "c.storage.googleapis.com",
+				"files.example.com":                                      "cdn.example.net",
+				"cdn.example.net":                                        "oldaccount.blob.core.windows.net",
+				"live.example.com":                                       "live.example.com.s3.amazonaws.com",
+				"current.example.com":                                    "current.blob.core.windows.net",
+				"current.blob.core.windows.net":                          "blob.ams.store.core.windows.net",
+				"live.example.com.s3.amazonaws.com":                      "s3-1-w.amazonaws.com",
+				"website.example.com.s3-website.eu-west-1.amazonaws.com": "s3-website.eu-west-1.amazonaws.com",
+			},
+			hosts: map[string][]string{
+				"www.example.com":                    {"192.0.2.1"},
+				"s3-1-w.amazonaws.com":               {"192.0.2.2"},
+				"s3-website.eu-west-1.amazonaws.com": {"192.0.2.3"},
+				"c.storage.googleapis.com":           {"192.0.2.4"},
+				"blob.ams.store.core.windows.net":    {"192.0.2.5"},
+			},
+		},
+		Client: client,
+	}
+
+	tests := []struct {
+		input string
+		want  Finding
+	}{
+		{"https://assets.example.com/index.html", Finding{
+			Input: "https://assets.example.com/index.html", Host: "assets.example.com",
+			CNAME: "assets.example.com.s3.amazonaws.com", Provider: "Amazon S3", Status: 404, Code: "NoSuchBucket",
+			Dangling: true, Claimable: true, BucketName: "assets.example.com", Note: Providers[0].Note,
+		}},
+		{"website.example.com", Finding{
+			Input: "website.example.com", Host: "website.example.com",
+			CNAME: "website.example.com.s3-website.eu-west-1.amazonaws.com", Provider: "Amazon S3", Status: 404, Code: "NoSuchBucket",
+			Dangling: true, Claimable: true, BucketName: "website.example.com", Note: Providers[0].Note,
+		}},
+		{"static.example.com", Finding{
+			Input: "static.example.com", Host: "static.example.com",
+			CNAME: "c.storage.googleapis.com", Provider: "Google Cloud Storage", Status: 404, Code: "NoSuchBucket",
+			Dangling: true, Claimable: false, BucketName: "static.example.com", Note: Providers[1].Note,
+		}},
+		// A deleted account's endpoint no longer resolves
+		{"files.example.com", Finding{
+			Input: "files.example.com", Host: "files.example.com",
+			CNAME: "oldaccount.blob.core.windows.net", Provider: "Azure Blob Storage",
+			Dangling: true, Claimable: true, BucketName: "oldaccount", Note: Providers[3].Note,
+		}},
+		{"current.example.com", Finding{
+			Input: "current.example.com", Host: "current.example.com",
+			CNAME: "current.blob.core.windows.net", Provider: "Azure Blob Storage", Status: 200,
+		}},
+		{"live.example.com", Finding{
+			Input: "live.example.com", Host: "live.example.com",
+			CNAME: "live.example.com.s3.amazonaws.com", Provider: "Amazon S3", Status: 403,
+		}},
+		{"www.example.com", Finding{
+			Input: "www.example.com", Host: "www.example.com",
+		}},
+	}
+	for _, test := range tests {
+		got := checker.Check(context.Background(), test.input)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Check(%q) = %+v, want %+v", test.input, got, test.want)
+		}
+	}
+}
+
+// Returns a DNS response to a query, with the specified response code and
+// a CNAME record for the queried name if target is set.
+func dnsResponse(query []byte, rcode byte, target string) []byte {
+	// Copy the header and question, then point the answer's name at the question
+	msg := append([]byte{}, query...)
+	msg[2] |= 0x80
+	msg[3] = 0x80 | rcode
+	if target == "" {
+		return msg
+	}
+	msg[7] = 1
+	var rdata []byte
+	for _, label := range strings.Split(target, ".") {
+		rdata = append(rdata, byte(len(label)))
+		rdata = append(rdata, label...)
+	}
+	rdata = append(rdata, 0)
+	msg = append(msg, 0xc0, 12, 0, dnsTypeCNAME, 0, dnsClassINET, 0, 0, 1, 0, byte(len(rdata)>>8), byte(len(rdata)))
+	return append(msg, rdata...)
+}
+
+func TestDNSResolver(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("Can't listen for DNS queries: %s", err)
+	}
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			query := buf[:n]
+			name, _, _ := readName(query, 12)
+			var response []byte
+			switch name {
+			case "files.example.com":
+				response = dnsResponse(query, 0, "oldaccount.blob.core.windows.net")
+			case "www.example.com":
+				response = dnsResponse(query, 0, "")
+			default:
+				response = dnsResponse(query, dnsRcodeNXDomain, "")
+			}
+			conn.WriteTo(response, addr)
+		}
+	}()
+
+	resolver := DNSResolver{Server: conn.LocalAddr().String(), Timeout: 2 * time.Second}
+	cname, err := resolver.LookupCNAME(context.Background(), "files.example.com")
+	if err != nil || cname != "oldaccount.blob.core.windows.net" {
+		t.Errorf("LookupCNAME(files.example.com) = %q, %v, want the unresolvable target", cname, err)
+	}
+	cname, err = resolver.LookupCNAME(context.Background(), "www.example.com")
+	if err != nil || cname != "" {
+		t.Errorf("LookupCNAME(www.example.com) = %q, %v, want no CNAME", cname, err)
+	}
+	if _, err = resolver.LookupCNAME(context.Background(), "missing.example.com"); !isNotFound(err) {
+		t.Errorf("LookupCNAME(missing.example.com) returned error %v, want not found", err)
+	}
+}
+
+func TestParseCNAME(t *testing.T) {
+	query, _, err := dnsQuery("files.example.com", dnsTypeCNAME)
+	if err != nil {
+		t.Fatalf("dnsQuery failed: %s", err)
+	}
+	truncated := dnsResponse(query, 0, "")
+	truncated[2] |= 0x02
+
+	tests := []struct {
+		name    string
+		msg     []byte
+		want    string
+		wantErr string
+	}{
+		{"answer", dnsResponse(query, 0, "oldaccount.blob.core.windows.net"), "oldaccount.blob.core.windows.net", ""},
+		{"query echoed back", query, "", "not a response"},
+		{"truncated", truncated, "", "truncated"},
+		{"short", query[:8], "", "malformed"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseCNAME(test.msg, "files.example.com", "test")
+			if test.wantErr == "" && (err != nil || got != test.want) {
+				t.Errorf("parseCNAME = %q, %v, want %q", got, err, test.want)
+			}
+			if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Errorf("parseCNAME returned error %v, want one containing %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestDNSResolverTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("Can't listen for DNS queries: %s", err)
+	}
+	defer listener.Close()
+	conn, err := net.ListenPacket("udp", listener.Addr().String())
+	if err != nil {
+		t.Skipf("Can't listen for DNS queries: %s", err)
+	}
+	defer conn.Close()
+
+	// Answer over UDP with a truncated response, and in full over TCP
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			response := dnsResponse(buf[:n], 0, "")
+			response[2] |= 0x02
+			conn.WriteTo(response, addr)
+		}
+	}()
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			var length [2]byte
+			if _, err := io.ReadFull(c, length[:]); err == nil {
+				query := make([]byte, int(length[0])<<8|int(length[1]))
+				if _, err := io.ReadFull(c, query); err == nil {
+					response := dnsResponse(query, 0, "oldaccount.blob.core.windows.net")
+					c.Write(append([]byte{byte(len(response) >> 8), byte(len(response))}, response...))
+				}
+			}
+			c.Close()
+		}
+	}()
+
+	resolver := DNSResolver{Server: listener.Addr().String(), Timeout: 2 * time.Second}
+	cname, err := resolver.LookupCNAME(context.Background(), "files.example.com")
+	if err != nil || cname != "oldaccount.blob.core.windows.net" {
+		t.Errorf("LookupCNAME(files.example.com) = %q, %v, want the target returned over TCP", cname, err)
+	}
+}
+
+func TestSystemDNSServer(t *testing.T) {
+	defer func(path string) { resolvConf = path }(resolvConf)
+	dir := t.TempDir()
+
+	resolvConf = filepath.Join(dir, "resolv.conf")
+	if err := os.WriteFile(resolvConf, []byte("# comment\nsearch example.com\nnameserver 192.0.2.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if server, err := systemDNSServer(); err != nil || server != "192.0.2.1:53" {
+		t.Errorf("systemDNSServer() = %q, %v, want 192.0.2.1:53", server, err)
+	}
+
+	if err := os.WriteFile(resolvConf, []byte("search example.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (DNSResolver{}).LookupCNAME(context.Background(), "files.example.com"); err == nil || !strings.Contains(err.Error(), "no nameserver") {
+		t.Errorf("LookupCNAME returned error %v, want no nameserver configured", err)
+	}
+
+	resolvConf = filepath.Join(dir, "missing")
+	if _, err := systemDNSServer(); err == nil {
+		t.Error("systemDNSServer() returned no error for a missing resolv.conf")
+	}
+}
+
+func TestLabelsBefore(t *testing.T) {
+	tests := []struct {
+		host   string
+		prefix string
+		want   string
+	}{
+		{"example.s3.amazonaws.com", "s3", "example"},
+		{"my.bucket.s3.eu-west-1.amazonaws.com", "s3", "my.bucket"},
+		{"example.s3-website-us-east-1.amazonaws.com", "s3", "example"},
+		{"s3.amazonaws.com", "s3", "s3.amazonaws.com"},
+		{"example.oss-cn-hangzhou.aliyuncs.com", "oss", "example"},
+	}
+	for _, test := range tests {
+		if got := labelsBefore(test.host, test.prefix); got != test.want {
+			t.Errorf("labelsBefore(%q, %q) = %q, want %q", test.host, test.prefix, got, test.want)
+		}
+	}
+}